@@ -0,0 +1,143 @@
+package assets
+
+// third-party files that rule html loads from /assets/ instead of a cdn
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/ccammack/cannon/config"
+)
+
+// rule html refers to the assets directory with the {assets} placeholder
+const Prefix = "/assets"
+
+type asset struct {
+	path   string // relative to the assets directory
+	url    string
+	sha256 string // hex digest of the pinned file; files without one are never installed
+}
+
+// pinned versions fetched by cannond assets fetch; the glTF rule imports these
+var pinned = []asset{
+	{"three@0.172.0/build/three.module.js", "https://cdn.jsdelivr.net/npm/three@0.172.0/build/three.module.js", ""},
+	{"three@0.172.0/build/three.core.js", "https://cdn.jsdelivr.net/npm/three@0.172.0/build/three.core.js", ""},
+	{"three@0.172.0/examples/jsm/controls/OrbitControls.js", "https://cdn.jsdelivr.net/npm/three@0.172.0/examples/jsm/controls/OrbitControls.js", ""},
+	{"three@0.172.0/examples/jsm/loaders/GLTFLoader.js", "https://cdn.jsdelivr.net/npm/three@0.172.0/examples/jsm/loaders/GLTFLoader.js", ""},
+	{"three@0.172.0/examples/jsm/utils/BufferGeometryUtils.js", "https://cdn.jsdelivr.net/npm/three@0.172.0/examples/jsm/utils/BufferGeometryUtils.js", ""},
+}
+
+func Dir() string {
+	// keep the assets in the user's cache unless the config says otherwise
+	_, dir := config.Assets().String()
+	if dir == "" {
+		return filepath.Join(xdg.CacheHome, "cannon", "assets")
+	}
+	return dir
+}
+
+func Missing() []string {
+	missing := []string{}
+	dir := Dir()
+	for _, a := range pinned {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(a.path))); err != nil {
+			missing = append(missing, a.path)
+		}
+	}
+	return missing
+}
+
+func download(client *http.Client, a asset, file string) (int64, error) {
+	resp, err := client.Get(a.url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: %s", a.url, resp.Status)
+	}
+
+	// write beside the destination and rename so a failed download leaves nothing behind
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return 0, err
+	}
+	fp, err := os.CreateTemp(filepath.Dir(file), ".fetch")
+	if err != nil {
+		return 0, err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(fp, hash), resp.Body)
+	fp.Close()
+
+	// refuse files that differ from the pinned version
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if err == nil && a.sha256 == "" {
+		err = fmt.Errorf("%s: no sha256 pinned (downloaded %s)", a.url, digest)
+	} else if err == nil && digest != a.sha256 {
+		err = fmt.Errorf("%s: sha256 %s does not match the pinned %s", a.url, digest, a.sha256)
+	}
+	if err == nil {
+		err = os.Rename(fp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(fp.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+func Fetch(force bool) error {
+	// download each pinned asset once
+	client := &http.Client{Timeout: 60 * time.Second}
+	dir := Dir()
+	failed := 0
+	for _, a := range pinned {
+		file := filepath.Join(dir, filepath.FromSlash(a.path))
+		if _, err := os.Stat(file); err == nil && !force {
+			log.Printf("Skip existing asset: %s", a.path)
+			continue
+		}
+		n, err := download(client, a, file)
+		if err != nil {
+			log.Printf("Error fetching asset %s: %v", a.path, err)
+			failed++
+			continue
+		}
+		log.Printf("Fetched asset: %s (%d bytes)", a.path, n)
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d assets could not be fetched", failed, len(pinned))
+	}
+	log.Printf("Assets are in %s", dir)
+	return nil
+}
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	// serve files from the assets directory without listing it
+	if strings.HasSuffix(r.URL.Path, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	// send the browser to the cdn for pinned files that have not been fetched
+	path := strings.TrimPrefix(r.URL.Path, Prefix+"/")
+	for _, a := range pinned {
+		if a.path != path {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(Dir(), filepath.FromSlash(a.path))); err != nil {
+			http.Redirect(w, r, a.url, http.StatusFound)
+			return
+		}
+	}
+	http.StripPrefix(Prefix+"/", http.FileServer(http.Dir(Dir()))).ServeHTTP(w, r)
+}
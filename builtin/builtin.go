@@ -0,0 +1,36 @@
+package builtin
+
+// file converters implemented in go and selected by the *builtin: rule key
+
+import (
+	"context"
+	"sort"
+)
+
+type Request struct {
+	Input  string // {input} or the file produced by the rule's cmd
+	Output string // {output} prefix for any files the converter creates
+	Url    string // {url} that serves the resource's src file
+}
+
+type Converter func(ctx context.Context, req Request) (string, error)
+
+var converters = map[string]Converter{}
+
+func register(name string, converter Converter) {
+	converters[name] = converter
+}
+
+func Lookup(name string) (Converter, bool) {
+	converter, ok := converters[name]
+	return converter, ok
+}
+
+func Names() []string {
+	names := []string{}
+	for name := range converters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
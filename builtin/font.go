@@ -0,0 +1,488 @@
+package builtin
+
+// render a specimen page for truetype, opentype, woff and woff2 fonts
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	fontMaxLength   = 64 * 1024 * 1024
+	fontMaxGlyphs   = 4096
+	fontMaxScan     = 4 * (unicode.MaxRune + 1) // code points examined across all cmap ranges
+	fontPangram     = "The quick brown fox jumps over the lazy dog 0123456789"
+	fontFamilyAlias = "cannon-specimen"
+)
+
+var fontSizes = []int{12, 18, 24, 36, 48, 72}
+
+// woff2 known table tags indexed by the low six bits of the table flags
+var woff2Tags = []string{
+	"cmap", "head", "hhea", "hmtx", "maxp", "name", "OS/2", "post", "cvt ", "fpgm",
+	"glyf", "loca", "prep", "CFF ", "VORG", "EBDT", "EBLC", "gasp", "hdmx", "kern",
+	"LTSH", "PCLT", "VDMX", "vhea", "vmtx", "BASE", "GDEF", "GPOS", "GSUB", "EBSC",
+	"JSTF", "MATH", "CBDT", "CBLC", "COLR", "CPAL", "SVG ", "sbix", "acnt", "avar",
+	"bdat", "bloc", "bsln", "cvar", "fdsc", "feat", "fmtx", "fvar", "gvar", "hsty",
+	"just", "lcar", "mort", "morx", "opbd", "prop", "trak", "Zapf", "Silf", "Glat",
+	"Gloc", "Feat", "Sill",
+}
+
+type fontInfo struct {
+	format  string
+	family  string
+	style   string
+	full    string
+	version string
+	glyphs  int
+	runes   []rune
+}
+
+func init() {
+	register("font", convertFont)
+}
+
+func convertFont(ctx context.Context, req Request) (string, error) {
+	data, err := os.ReadFile(req.Input)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > fontMaxLength {
+		return "", errors.New("font file too large")
+	}
+
+	tables, format, err := fontTables(data)
+	if err != nil {
+		return "", err
+	}
+
+	info := &fontInfo{format: format}
+	if name, ok := tables["name"]; ok {
+		parseFontNames(name, info)
+	}
+	if maxp, ok := tables["maxp"]; ok && len(maxp) >= 6 {
+		info.glyphs = int(binary.BigEndian.Uint16(maxp[4:6]))
+	}
+	if cmap, ok := tables["cmap"]; ok {
+		info.runes, err = parseFontCmap(ctx, cmap)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	return fontSpecimen(info, req.Url), nil
+}
+
+func fontTables(data []byte) (map[string][]byte, string, error) {
+	if len(data) < 12 {
+		return nil, "", errors.New("font file too short")
+	}
+	switch string(data[0:4]) {
+	case "wOFF":
+		return woffTables(data)
+	case "wOF2":
+		return woff2Tables(data)
+	case "ttcf":
+		// use the first font in a collection
+		if len(data) < 16 || binary.BigEndian.Uint32(data[8:12]) == 0 {
+			return nil, "", errors.New("empty font collection")
+		}
+		tables, err := sfntTables(data, int(binary.BigEndian.Uint32(data[12:16])))
+		return tables, "TrueType collection", err
+	case "OTTO":
+		tables, err := sfntTables(data, 0)
+		return tables, "OpenType (CFF)", err
+	case "\x00\x01\x00\x00", "true":
+		tables, err := sfntTables(data, 0)
+		return tables, "TrueType", err
+	}
+	return nil, "", errors.New("unknown font format")
+}
+
+func sfntTables(data []byte, offset int) (map[string][]byte, error) {
+	if offset+12 > len(data) {
+		return nil, errors.New("invalid font offset")
+	}
+	count := int(binary.BigEndian.Uint16(data[offset+4 : offset+6]))
+	tables := map[string][]byte{}
+	for i := 0; i < count; i++ {
+		entry := offset + 12 + 16*i
+		if entry+16 > len(data) {
+			return nil, errors.New("truncated font table directory")
+		}
+		tag := string(data[entry : entry+4])
+		start := int(binary.BigEndian.Uint32(data[entry+8 : entry+12]))
+		length := int(binary.BigEndian.Uint32(data[entry+12 : entry+16]))
+		if start < 0 || length < 0 || start+length > len(data) {
+			continue
+		}
+		tables[tag] = data[start : start+length]
+	}
+	return tables, nil
+}
+
+func woffTables(data []byte) (map[string][]byte, string, error) {
+	if len(data) < 44 {
+		return nil, "", errors.New("truncated woff header")
+	}
+	count := int(binary.BigEndian.Uint16(data[12:14]))
+	tables := map[string][]byte{}
+	for i := 0; i < count; i++ {
+		entry := 44 + 20*i
+		if entry+20 > len(data) {
+			return nil, "", errors.New("truncated woff table directory")
+		}
+		tag := string(data[entry : entry+4])
+		start := int(binary.BigEndian.Uint32(data[entry+4 : entry+8]))
+		compLength := int(binary.BigEndian.Uint32(data[entry+8 : entry+12]))
+		origLength := int(binary.BigEndian.Uint32(data[entry+12 : entry+16]))
+		if start < 0 || compLength < 0 || start+compLength > len(data) {
+			continue
+		}
+		table := data[start : start+compLength]
+		if compLength < origLength {
+			// only read the tables needed for the specimen
+			if tag != "name" && tag != "maxp" && tag != "cmap" {
+				continue
+			}
+			r, err := zlib.NewReader(bytes.NewReader(table))
+			if err != nil {
+				return nil, "", err
+			}
+			table, err = io.ReadAll(io.LimitReader(r, int64(origLength)))
+			r.Close()
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		tables[tag] = table
+	}
+	return tables, "WOFF", nil
+}
+
+func readBase128(data []byte, pos *int) (int, error) {
+	value := 0
+	for i := 0; i < 5; i++ {
+		if *pos >= len(data) {
+			return 0, errors.New("truncated woff2 directory")
+		}
+		b := data[*pos]
+		*pos++
+		if i == 0 && b == 0x80 {
+			return 0, errors.New("invalid woff2 integer")
+		}
+		value = value<<7 | int(b&0x7f)
+		if b&0x80 == 0 {
+			return value, nil
+		}
+	}
+	return 0, errors.New("invalid woff2 integer")
+}
+
+func woff2Tables(data []byte) (map[string][]byte, string, error) {
+	if len(data) < 48 {
+		return nil, "", errors.New("truncated woff2 header")
+	}
+	if string(data[4:8]) == "ttcf" {
+		return nil, "", errors.New("woff2 font collections are not supported")
+	}
+	count := int(binary.BigEndian.Uint16(data[12:14]))
+	compressed := int(binary.BigEndian.Uint32(data[20:24]))
+
+	// read the table directory
+	type entry struct {
+		tag    string
+		length int
+	}
+	entries := []entry{}
+	pos := 48
+	for i := 0; i < count; i++ {
+		if pos >= len(data) {
+			return nil, "", errors.New("truncated woff2 directory")
+		}
+		flags := data[pos]
+		pos++
+		tag := ""
+		if idx := int(flags & 0x3f); idx < len(woff2Tags) {
+			tag = woff2Tags[idx]
+		} else {
+			if pos+4 > len(data) {
+				return nil, "", errors.New("truncated woff2 directory")
+			}
+			tag = string(data[pos : pos+4])
+			pos += 4
+		}
+		length, err := readBase128(data, &pos)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// glyf and loca use transform 0 to mean transformed; other tables use it for none
+		transform := flags >> 6
+		if (tag == "glyf" || tag == "loca") == (transform == 0) {
+			if length, err = readBase128(data, &pos); err != nil {
+				return nil, "", err
+			}
+		}
+		entries = append(entries, entry{tag, length})
+	}
+
+	// the tables are stored back to back in a single brotli stream
+	if pos+compressed > len(data) {
+		return nil, "", errors.New("truncated woff2 data")
+	}
+	stream, err := io.ReadAll(io.LimitReader(brotli.NewReader(bytes.NewReader(data[pos:pos+compressed])), fontMaxLength))
+	if err != nil {
+		return nil, "", err
+	}
+	tables := map[string][]byte{}
+	offset := 0
+	for _, e := range entries {
+		if offset+e.length > len(stream) {
+			return nil, "", errors.New("truncated woff2 table")
+		}
+		tables[e.tag] = stream[offset : offset+e.length]
+		offset += e.length
+	}
+	return tables, "WOFF2", nil
+}
+
+func parseFontNames(table []byte, info *fontInfo) {
+	if len(table) < 6 {
+		return
+	}
+	count := int(binary.BigEndian.Uint16(table[2:4]))
+	storage := int(binary.BigEndian.Uint16(table[4:6]))
+
+	// prefer windows unicode names, then mac roman
+	names := map[int]string{}
+	ranks := map[int]int{}
+	for i := 0; i < count; i++ {
+		record := 6 + 12*i
+		if record+12 > len(table) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(table[record : record+2])
+		language := binary.BigEndian.Uint16(table[record+4 : record+6])
+		id := int(binary.BigEndian.Uint16(table[record+6 : record+8]))
+		length := int(binary.BigEndian.Uint16(table[record+8 : record+10]))
+		offset := storage + int(binary.BigEndian.Uint16(table[record+10:record+12]))
+		if offset+length > len(table) {
+			continue
+		}
+		raw := table[offset : offset+length]
+
+		rank := 0
+		value := ""
+		switch {
+		case platform == 3 && language == 0x409:
+			rank, value = 3, decodeUTF16BE(raw)
+		case platform == 3 || platform == 0:
+			rank, value = 2, decodeUTF16BE(raw)
+		case platform == 1:
+			rank, value = 1, string(raw)
+		default:
+			continue
+		}
+		if rank > ranks[id] {
+			ranks[id] = rank
+			names[id] = strings.TrimSpace(value)
+		}
+	}
+
+	// typographic family and subfamily take precedence when present
+	info.family = firstNonEmpty(names[16], names[1])
+	info.style = firstNonEmpty(names[17], names[2])
+	info.full = names[4]
+	info.version = names[5]
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func decodeUTF16BE(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+func parseFontCmap(ctx context.Context, table []byte) ([]rune, error) {
+	if len(table) < 4 {
+		return nil, nil
+	}
+	count := int(binary.BigEndian.Uint16(table[2:4]))
+
+	// pick the unicode subtable with the widest coverage
+	best, bestRank := -1, 0
+	for i := 0; i < count; i++ {
+		record := 4 + 8*i
+		if record+8 > len(table) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(table[record : record+2])
+		encoding := binary.BigEndian.Uint16(table[record+2 : record+4])
+		offset := int(binary.BigEndian.Uint32(table[record+4 : record+8]))
+		if offset+2 > len(table) {
+			continue
+		}
+		format := binary.BigEndian.Uint16(table[offset : offset+2])
+		rank := 0
+		switch {
+		case format == 12 && (platform == 0 || (platform == 3 && encoding == 10)):
+			rank = 2
+		case format == 4 && (platform == 0 || (platform == 3 && encoding == 1)):
+			rank = 1
+		}
+		if rank > bestRank {
+			best, bestRank = offset, rank
+		}
+	}
+	if best < 0 {
+		return nil, nil
+	}
+
+	// the ranges come from the file, so bound the work they can ask for
+	set := map[rune]bool{}
+	scanned := 0
+	add := func(start, end uint32) error {
+		if end > unicode.MaxRune {
+			end = unicode.MaxRune
+		}
+		for r := start; r <= end && len(set) < fontMaxGlyphs; r++ {
+			scanned++
+			if scanned > fontMaxScan {
+				return errors.New("font cmap ranges too large")
+			}
+			if scanned%65536 == 0 && ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			// include the private use area for icon fonts
+			if (unicode.IsPrint(rune(r)) || unicode.Is(unicode.Co, rune(r))) && !unicode.IsSpace(rune(r)) {
+				set[rune(r)] = true
+			}
+		}
+		return nil
+	}
+
+	sub := table[best:]
+	switch binary.BigEndian.Uint16(sub[0:2]) {
+	case 4:
+		if len(sub) < 14 {
+			return nil, nil
+		}
+		segments := int(binary.BigEndian.Uint16(sub[6:8])) / 2
+		if 16+8*segments > len(sub) {
+			return nil, nil
+		}
+		for s := 0; s < segments; s++ {
+			end := uint32(binary.BigEndian.Uint16(sub[14+2*s:]))
+			start := uint32(binary.BigEndian.Uint16(sub[16+2*segments+2*s:]))
+			if start != 0xffff {
+				if err := add(start, end); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case 12:
+		if len(sub) < 16 {
+			return nil, nil
+		}
+		groups := int(binary.BigEndian.Uint32(sub[12:16]))
+		for g := 0; g < groups; g++ {
+			entry := 16 + 12*g
+			if entry+12 > len(sub) {
+				break
+			}
+			if err := add(binary.BigEndian.Uint32(sub[entry:]), binary.BigEndian.Uint32(sub[entry+4:])); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	runes := make([]rune, 0, len(set))
+	for r := range set {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	return runes, nil
+}
+
+func fontSpecimen(info *fontInfo, url string) string {
+	esc := template.HTMLEscapeString
+	var b strings.Builder
+
+	b.WriteString(`<style>
+		@font-face { font-family: "` + fontFamilyAlias + `"; src: url("` + esc(url) + `"); }
+		.font-specimen { font-family: sans-serif; }
+		.font-specimen .font-face { font-family: "` + fontFamilyAlias + `", monospace; }
+		.font-specimen th { text-align: left; padding-right: 1em; }
+		.font-specimen .font-sample { border: 1px dashed #aaa; padding: 0.25em; margin: 0.5em 0; font-size: 36px; }
+		.font-specimen .font-line { white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+		.font-specimen .font-line small { font-family: sans-serif; color: #888; display: inline-block; width: 3em; }
+		.font-specimen .font-grid { display: flex; flex-wrap: wrap; }
+		.font-specimen .font-grid span { width: 2em; height: 2em; line-height: 2em; text-align: center; border: 1px solid #eee; font-size: 20px; }
+	</style>`)
+	b.WriteString(`<div class="font-specimen"><table>`)
+	rows := [][2]string{
+		{"Family", info.family},
+		{"Style", info.style},
+		{"Full name", info.full},
+		{"Version", info.version},
+		{"Format", info.format},
+	}
+	if info.glyphs > 0 {
+		rows = append(rows, [2]string{"Glyphs", fmt.Sprintf("%d", info.glyphs)})
+	}
+	for _, row := range rows {
+		if row[1] != "" {
+			b.WriteString(`<tr><th>` + esc(row[0]) + `</th><td>` + esc(row[1]) + `</td></tr>`)
+		}
+	}
+	b.WriteString(`</table>`)
+
+	// editing the sample updates every size below
+	b.WriteString(`<div class="font-face font-sample" contenteditable="true" spellcheck="false">` + fontPangram + `</div>`)
+	for _, size := range fontSizes {
+		b.WriteString(fmt.Sprintf(`<div class="font-line"><small>%dpx</small><span class="font-face" style="font-size: %dpx">%s</span></div>`, size, size, fontPangram))
+	}
+	b.WriteString(`<script>
+		document.querySelector(".font-sample").addEventListener("input", (e) => {
+			document.querySelectorAll(".font-line .font-face").forEach((line) => { line.textContent = e.target.textContent })
+		})
+	</script>`)
+
+	if len(info.runes) > 0 {
+		b.WriteString(fmt.Sprintf(`<h3>Characters (%d)</h3><div class="font-face font-grid">`, len(info.runes)))
+		for _, r := range info.runes {
+			b.WriteString(fmt.Sprintf(`<span title="U+%04X">%s</span>`, r, esc(string(r))))
+		}
+		b.WriteString(`</div>`)
+	}
+	b.WriteString(`</div>`)
+	return b.String()
+}
@@ -0,0 +1,190 @@
+package builtin
+
+// list the schema, row counts and first rows of a sqlite database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	sqliteMaxObjects = 100
+	sqliteMaxRows    = 20
+	sqliteMaxCell    = 200
+)
+
+var sqliteHeadings = map[string]string{
+	"table": "Tables",
+	"view":  "Views",
+	"index": "Indexes",
+}
+
+type sqliteObject struct {
+	kind   string
+	name   string
+	table  string
+	schema string
+	count  int64
+}
+
+func init() {
+	register("sqlite", convertSQLite)
+}
+
+func sqliteURI(file string) string {
+	// open read-only so previews never modify or lock the database for writing
+	path := filepath.ToSlash(file)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	uri := url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}
+	return uri.String()
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func convertSQLite(ctx context.Context, req Request) (string, error) {
+	db, err := sql.Open("sqlite", sqliteURI(req.Input))
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	// list the schema objects
+	rows, err := db.QueryContext(ctx, `SELECT type, name, tbl_name, coalesce(sql, '') FROM sqlite_master
+		WHERE type IN ('table', 'view', 'index') ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'view' THEN 1 ELSE 2 END, name`)
+	if err != nil {
+		return "", err
+	}
+	objects := []*sqliteObject{}
+	for rows.Next() {
+		obj := &sqliteObject{count: -1}
+		if err := rows.Scan(&obj.kind, &obj.name, &obj.table, &obj.schema); err != nil {
+			rows.Close()
+			return "", err
+		}
+		objects = append(objects, obj)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(`<div class="sqlite">`)
+	if len(objects) == 0 {
+		b.WriteString(`<p>Empty database</p>`)
+	}
+
+	kind := ""
+	for idx, obj := range objects {
+		if idx >= sqliteMaxObjects {
+			b.WriteString(fmt.Sprintf(`<p>[...] %d more objects</p>`, len(objects)-idx))
+			break
+		}
+		if obj.kind != kind {
+			kind = obj.kind
+			b.WriteString(`<h3>` + sqliteHeadings[kind] + `</h3>`)
+		}
+
+		// count the rows in each table
+		if obj.kind == "table" {
+			row := db.QueryRowContext(ctx, `SELECT count(*) FROM `+quoteIdentifier(obj.name))
+			if err := row.Scan(&obj.count); err != nil {
+				obj.count = -1
+			}
+		}
+
+		summary := template.HTMLEscapeString(obj.name)
+		switch {
+		case obj.kind == "index":
+			summary += " on " + template.HTMLEscapeString(obj.table)
+		case obj.count >= 0:
+			summary += fmt.Sprintf(" (%d rows)", obj.count)
+		}
+		b.WriteString(`<details open><summary>` + summary + `</summary>`)
+		if obj.schema != "" {
+			b.WriteString(`<pre>` + template.HTMLEscapeString(obj.schema) + `</pre>`)
+		}
+		if obj.kind == "table" && obj.count != 0 {
+			if err := writeSQLiteRows(ctx, db, obj.name, &b); err != nil {
+				b.WriteString(`<p>Error reading rows: ` + template.HTMLEscapeString(err.Error()) + `</p>`)
+			}
+		}
+		b.WriteString(`</details>`)
+
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+	}
+	b.WriteString(`</div>`)
+
+	return b.String(), nil
+}
+
+func writeSQLiteRows(ctx context.Context, db *sql.DB, table string, b *strings.Builder) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM %s LIMIT %d`, quoteIdentifier(table), sqliteMaxRows))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	b.WriteString(`<table><tr>`)
+	for _, column := range columns {
+		b.WriteString(`<th>` + template.HTMLEscapeString(column) + `</th>`)
+	}
+	b.WriteString(`</tr>`)
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		b.WriteString(`<tr>`)
+		for _, value := range values {
+			b.WriteString(`<td>` + template.HTMLEscapeString(formatSQLiteValue(value)) + `</td>`)
+		}
+		b.WriteString(`</tr>`)
+	}
+	b.WriteString(`</table>`)
+
+	return rows.Err()
+}
+
+func formatSQLiteValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		if !utf8.Valid(v) {
+			return fmt.Sprintf("<blob %d bytes>", len(v))
+		}
+		s = string(v)
+	default:
+		s = fmt.Sprint(v)
+	}
+	if utf8.RuneCountInString(s) > sqliteMaxCell {
+		s = string([]rune(s)[:sqliteMaxCell]) + " [...]"
+	}
+	return s
+}
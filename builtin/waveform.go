@@ -0,0 +1,400 @@
+package builtin
+
+// decode wav, flac and mp3 audio and draw an svg waveform that seeks the page's audio player
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/mewkiz/flac"
+)
+
+const (
+	waveformWidth  = 1000
+	waveformHeight = 100
+	waveformBlock  = 256 // frames summarized by each peak before resampling to the svg width
+	maxFmtSize     = 64  // largest wav fmt chunk accepted; the extensible format uses 40 bytes
+)
+
+var errUnknownAudio = errors.New("unknown audio format")
+
+const waveformTemplate = `
+<style>
+	.waveform { position: relative; width: 100%; height: 120px; cursor: pointer; font-family: sans-serif; font-size: x-small; color: #888; }
+	.waveform svg { position: absolute; top: 0; left: 0; width: 100%; height: 100px; }
+	.waveform .wave { fill: #4a90d9; }
+	.waveform .tick { stroke: #ccc; stroke-width: 1; vector-effect: non-scaling-stroke; }
+	.waveform .label { position: absolute; top: 104px; transform: translateX(-50%); }
+	.waveform .playhead { position: absolute; top: 0; left: 0; width: 1px; height: 100px; background: #d33; pointer-events: none; }
+</style>
+<div class="waveform" data-duration="{{.duration}}">
+	<svg viewBox="0 0 {{.width}} {{.height}}" preserveAspectRatio="none">
+		{{range .ticks}}<line class="tick" x1="{{.X}}" y1="0" x2="{{.X}}" y2="{{$.height}}"></line>{{end}}
+		<path class="wave" d="{{.path}}"></path>
+	</svg>
+	{{range .ticks}}<span class="label" style="left: {{.Percent}}%">{{.Label}}</span>{{end}}
+	<div class="playhead"></div>
+</div>
+<script>
+	(function() {
+		const waveform = document.querySelector(".waveform")
+		const playhead = waveform.querySelector(".playhead")
+		const player = () => document.querySelector("audio")
+		const duration = () => {
+			const audio = player()
+			return audio && isFinite(audio.duration) ? audio.duration : parseFloat(waveform.dataset.duration)
+		}
+		waveform.addEventListener("click", (e) => {
+			const audio = player()
+			if (audio) {
+				const rect = waveform.getBoundingClientRect()
+				audio.currentTime = (e.clientX - rect.left) / rect.width * duration()
+				audio.play()
+			}
+		})
+		const update = () => {
+			if (!waveform.isConnected) {
+				// the page swapped in another file
+				return
+			}
+			const audio = player()
+			if (audio && duration() > 0) {
+				playhead.style.left = (audio.currentTime / duration() * 100) + "%"
+			}
+			requestAnimationFrame(update)
+		}
+		update()
+	})()
+</script>
+`
+
+type waveformTick struct {
+	X       float64
+	Percent float64
+	Label   string
+}
+
+// running min/max of the decoded samples in fixed size blocks
+type peaks struct {
+	count  int
+	frames int64
+	lo, hi float64
+	min    []float64
+	max    []float64
+}
+
+func (p *peaks) add(frame []float64) {
+	if p.count == 0 {
+		p.lo, p.hi = 0, 0
+	}
+	for _, v := range frame {
+		p.lo = math.Min(p.lo, v)
+		p.hi = math.Max(p.hi, v)
+	}
+	p.count++
+	p.frames++
+	if p.count == waveformBlock {
+		p.flush()
+	}
+}
+
+func (p *peaks) flush() {
+	if p.count != 0 {
+		p.min = append(p.min, p.lo)
+		p.max = append(p.max, p.hi)
+		p.count = 0
+	}
+}
+
+func init() {
+	register("waveform", convertWaveform)
+}
+
+func convertWaveform(ctx context.Context, req Request) (string, error) {
+	p := &peaks{}
+	rate, err := decodeAudio(ctx, req.Input, p)
+	if err != nil {
+		return "", err
+	}
+	p.flush()
+	if rate <= 0 || len(p.min) == 0 {
+		return "", errors.New("no audio samples found")
+	}
+	duration := float64(p.frames) / float64(rate)
+
+	var b strings.Builder
+	templ := template.Must(template.New("waveform").Parse(waveformTemplate))
+	err = templ.Execute(&b, map[string]interface{}{
+		"width":    waveformWidth,
+		"height":   waveformHeight,
+		"duration": fmt.Sprintf("%.3f", duration),
+		"path":     waveformPath(p),
+		"ticks":    waveformTicks(duration),
+	})
+	return b.String(), err
+}
+
+func waveformPath(p *peaks) string {
+	// resample the peaks to one column per svg unit
+	columns := waveformWidth
+	if len(p.min) < columns {
+		columns = len(p.min)
+	}
+	lo := make([]float64, columns)
+	hi := make([]float64, columns)
+	for i := range lo {
+		start := i * len(p.min) / columns
+		end := (i + 1) * len(p.min) / columns
+		for j := start; j < end; j++ {
+			lo[i] = math.Min(lo[i], p.min[j])
+			hi[i] = math.Max(hi[i], p.max[j])
+		}
+	}
+
+	// trace the upper edge left to right and the lower edge back
+	scale := float64(waveformWidth) / float64(columns)
+	y := func(v float64) float64 {
+		v = math.Max(-1, math.Min(1, v))
+		// keep silence visible as a thin line
+		return waveformHeight/2 - v*(waveformHeight/2-1)
+	}
+	var b strings.Builder
+	for i := 0; i < columns; i++ {
+		cmd := "L"
+		if i == 0 {
+			cmd = "M"
+		}
+		fmt.Fprintf(&b, "%s%.1f %.1f", cmd, float64(i)*scale, math.Min(y(hi[i]), waveformHeight/2-0.5))
+		fmt.Fprintf(&b, "L%.1f %.1f", float64(i+1)*scale, math.Min(y(hi[i]), waveformHeight/2-0.5))
+	}
+	for i := columns - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "L%.1f %.1f", float64(i+1)*scale, math.Max(y(lo[i]), waveformHeight/2+0.5))
+		fmt.Fprintf(&b, "L%.1f %.1f", float64(i)*scale, math.Max(y(lo[i]), waveformHeight/2+0.5))
+	}
+	b.WriteString("Z")
+	return b.String()
+}
+
+func waveformTicks(duration float64) []waveformTick {
+	// pick the smallest round interval that keeps the labels readable
+	interval := 1.0
+	for _, seconds := range []float64{1, 2, 5, 10, 15, 30, 60, 120, 300, 600, 900, 1800, 3600} {
+		interval = seconds
+		if duration/seconds <= 10 {
+			break
+		}
+	}
+	ticks := []waveformTick{}
+	for t := interval; t < duration; t += interval {
+		percent := t / duration * 100
+		d := time.Duration(t) * time.Second
+		label := fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+		if d >= time.Hour {
+			label = fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+		}
+		ticks = append(ticks, waveformTick{percent * waveformWidth / 100, percent, label})
+	}
+	return ticks
+}
+
+func decodeAudio(ctx context.Context, file string, p *peaks) (int, error) {
+	fp, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer fp.Close()
+
+	head := make([]byte, 12)
+	n, _ := io.ReadFull(fp, head)
+	head = head[:n]
+
+	// look past an id3 tag, which may precede both mp3 and flac audio
+	tagged := bytes.HasPrefix(head, []byte("ID3")) && len(head) >= 10
+	if tagged {
+		size := int64(head[6]&0x7f)<<21 | int64(head[7]&0x7f)<<14 | int64(head[8]&0x7f)<<7 | int64(head[9]&0x7f)
+		if _, err := fp.Seek(10+size, io.SeekStart); err != nil {
+			return 0, err
+		}
+		n, _ = io.ReadFull(fp, head)
+		head = head[:n]
+	}
+	if _, err := fp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case len(head) == 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return decodeWAV(ctx, fp, p)
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return decodeFLAC(ctx, fp, p)
+	case tagged || (len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0):
+		return decodeMP3(ctx, fp, p)
+	}
+	return 0, errUnknownAudio
+}
+
+func decodeWAV(ctx context.Context, r io.ReadSeeker, p *peaks) (int, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return 0, err
+	}
+
+	var format, channels, bits uint16
+	var rate uint32
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return 0, errors.New("missing wav data chunk")
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			// the chunk is 16, 18 or 40 bytes; never trust the file to size an allocation
+			if size < 16 || size > maxFmtSize {
+				return 0, errors.New("invalid wav fmt chunk")
+			}
+			keep := size
+			if keep > 40 {
+				keep = 40
+			}
+			body := make([]byte, keep)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return 0, errors.New("invalid wav fmt chunk")
+			}
+			if _, err := io.CopyN(io.Discard, r, size-int64(len(body))); err != nil {
+				return 0, errors.New("invalid wav fmt chunk")
+			}
+			format = binary.LittleEndian.Uint16(body[0:2])
+			channels = binary.LittleEndian.Uint16(body[2:4])
+			rate = binary.LittleEndian.Uint32(body[4:8])
+			bits = binary.LittleEndian.Uint16(body[14:16])
+			if format == 0xfffe && size >= 26 {
+				// WAVE_FORMAT_EXTENSIBLE stores the real format in the subformat guid
+				format = binary.LittleEndian.Uint16(body[24:26])
+			}
+		case "data":
+			if channels == 0 || bits == 0 {
+				return 0, errors.New("wav data before fmt chunk")
+			}
+			if !(format == 1 && (bits == 8 || bits == 16 || bits == 24 || bits == 32)) && !(format == 3 && bits == 32) {
+				return 0, fmt.Errorf("unsupported wav encoding: format %d, %d bits", format, bits)
+			}
+			return int(rate), decodePCM(ctx, io.LimitReader(r, size), int(channels), int(bits), format == 3, p)
+		default:
+			if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if size%2 == 1 {
+			if _, err := r.Seek(1, io.SeekCurrent); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+func decodePCM(ctx context.Context, r io.Reader, channels, bits int, float bool, p *peaks) error {
+	width := bits / 8
+	buf := make([]byte, channels*width)
+	frame := make([]float64, channels)
+	br := bufio.NewReaderSize(r, 64*1024)
+	for i := 0; ; i++ {
+		if i%65536 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, err := io.ReadFull(br, buf); err != nil {
+			// ignore a truncated final frame
+			return nil
+		}
+		for c := 0; c < channels; c++ {
+			s := buf[c*width : (c+1)*width]
+			switch {
+			case float:
+				frame[c] = float64(math.Float32frombits(binary.LittleEndian.Uint32(s)))
+			case bits == 8:
+				frame[c] = (float64(s[0]) - 128) / 128
+			case bits == 16:
+				frame[c] = float64(int16(binary.LittleEndian.Uint16(s))) / (1 << 15)
+			case bits == 24:
+				v := int32(uint32(s[0])<<8|uint32(s[1])<<16|uint32(s[2])<<24) >> 8
+				frame[c] = float64(v) / (1 << 23)
+			default:
+				frame[c] = float64(int32(binary.LittleEndian.Uint32(s))) / (1 << 31)
+			}
+		}
+		p.add(frame)
+	}
+}
+
+func decodeFLAC(ctx context.Context, r io.ReadSeeker, p *peaks) (int, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	scale := math.Ldexp(1, int(stream.Info.BitsPerSample)-1)
+	frame := make([]float64, stream.Info.NChannels)
+	for {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		f, err := stream.ParseNext()
+		if err == io.EOF {
+			return int(stream.Info.SampleRate), nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		for i := 0; i < int(f.BlockSize); i++ {
+			for c, sub := range f.Subframes {
+				if c < len(frame) && i < len(sub.Samples) {
+					frame[c] = float64(sub.Samples[i]) / scale
+				}
+			}
+			p.add(frame)
+		}
+	}
+}
+
+func decodeMP3(ctx context.Context, r io.ReadSeeker, p *peaks) (int, error) {
+	decoder, err := mp3.NewDecoder(r)
+	if err != nil {
+		return 0, err
+	}
+
+	// the decoder always produces 16-bit little endian stereo
+	buf := make([]byte, 4*4096)
+	frame := make([]float64, 2)
+	for {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		n, err := io.ReadFull(decoder, buf)
+		for i := 0; i+4 <= n; i += 4 {
+			frame[0] = float64(int16(binary.LittleEndian.Uint16(buf[i:]))) / (1 << 15)
+			frame[1] = float64(int16(binary.LittleEndian.Uint16(buf[i+2:]))) / (1 << 15)
+			p.add(frame)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return decoder.SampleRate(), nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
@@ -2,8 +2,12 @@ package cache
 
 import (
 	"sync"
+
+	"github.com/ccammack/cannon/metrics"
 )
 
+var evictions = metrics.NewCounter("cannon_cache_evictions_total", "Resources closed and removed from the cache.")
+
 type Status int
 
 const (
@@ -21,6 +25,7 @@ type CacheItem struct {
 	key     string
 	status  Status
 	payload Payload
+	cache   *Cache
 	mu      sync.Mutex
 }
 
@@ -32,8 +37,9 @@ func (item *CacheItem) Open() {
 	go func() {
 		item.payload.Open()
 		item.mu.Lock()
-		defer item.mu.Unlock()
 		item.status = StatusReady
+		item.mu.Unlock()
+		item.cache.changed(item.key)
 	}()
 }
 
@@ -57,8 +63,9 @@ func (item *CacheItem) IsReady() bool {
 }
 
 type Cache struct {
-	items map[string]*CacheItem
-	mu    sync.RWMutex
+	items     map[string]*CacheItem
+	callbacks []func(key string)
+	mu        sync.RWMutex
 }
 
 func New() *Cache {
@@ -67,18 +74,39 @@ func New() *Cache {
 	}
 }
 
-func (c *Cache) Put(key string, payload Payload) {
+func (c *Cache) RegisterCallback(callback func(key string)) {
+	// called after an item is added, finishes opening or is evicted
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.callbacks = append(c.callbacks, callback)
+}
+
+func (c *Cache) changed(key string) {
+	// run the callbacks outside the lock so they can read the cache
+	c.mu.RLock()
+	callbacks := c.callbacks
+	c.mu.RUnlock()
+	for _, callback := range callbacks {
+		callback(key)
+	}
+}
+
+func (c *Cache) Put(key string, payload Payload) {
+	c.mu.Lock()
 	_, ok := c.items[key]
 	if !ok {
 		item := &CacheItem{
 			key:     key,
 			payload: payload,
+			cache:   c,
 		}
 		item.Open()
 		c.items[key] = item
 	}
+	c.mu.Unlock()
+	if !ok {
+		c.changed(key)
+	}
 }
 
 func (c *Cache) Get(key string) (Status, Payload) {
@@ -96,16 +124,50 @@ func (c *Cache) Get(key string) (Status, Payload) {
 
 func (c *Cache) Evict(key string) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	item, ok := c.items[key]
 	if ok {
 		item.Close()
 		delete(c.items, key)
+		evictions.Inc()
+	}
+	c.mu.Unlock()
+	if ok {
+		c.changed(key)
 	}
 }
 
 func (c *Cache) Clear() {
-	for hash := range c.items {
-		c.Evict(hash)
+	c.mu.RLock()
+	keys := []string{}
+	for key := range c.items {
+		keys = append(keys, key)
+	}
+	c.mu.RUnlock()
+	for _, key := range keys {
+		c.Evict(key)
+	}
+}
+
+func (c *Cache) Close() {
+	// close every payload and wait for each to finish
+	c.mu.Lock()
+	items := c.items
+	c.items = make(map[string]*CacheItem)
+	c.mu.Unlock()
+	for _, item := range items {
+		item.payload.Close()
+		evictions.Inc()
+	}
+}
+
+func (c *Cache) Each(fn func(key string, status Status, payload Payload)) {
+	// visit every item, including those still opening
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for key, item := range c.items {
+		item.mu.Lock()
+		status := item.status
+		item.mu.Unlock()
+		fn(key, status, item.payload)
 	}
 }
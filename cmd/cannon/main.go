@@ -5,10 +5,13 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/ccammack/cannon/config"
+	"github.com/ccammack/cannon/media"
 	"github.com/ccammack/cannon/resources"
 	"github.com/ccammack/cannon/server"
 	"github.com/ccammack/cannon/util"
@@ -18,6 +21,9 @@ import (
 func main() {
 	// process command line
 	close := false
+	compare := false
+	channel := ""
+	remote := false
 
 	app := &cli.App{
 		Name:     "Cannon",
@@ -32,7 +38,7 @@ func main() {
 		Copyright: "(c) 2022 Chris Cammack",
 		HelpName:  "cannon",
 		Usage:     "send a filename to the Cannon server for display in the web browser.",
-		UsageText: "cannon [OPTION]... file",
+		UsageText: "cannon [OPTION]... file\n   cannon --diff file other\n   cannon --channel name file\n   cannon --remote file",
 		ArgsUsage: "[global options] file",
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
@@ -53,10 +59,39 @@ func main() {
 					return nil
 				},
 			},
+			&cli.BoolFlag{
+				Name:    "diff",
+				Aliases: []string{"d"},
+				Usage:   "compare the two specified files.",
+				Action: func(ctx *cli.Context, v bool) error {
+					compare = v
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:    "channel",
+				Aliases: []string{"n"},
+				Usage:   "update only the browsers viewing /?channel=`NAME`.",
+				EnvVars: []string{"CANNON_CHANNEL"},
+				Action: func(ctx *cli.Context, v string) error {
+					channel = v
+					return nil
+				},
+			},
+			&cli.BoolFlag{
+				Name:    "remote",
+				Aliases: []string{"r"},
+				Usage:   "upload the file to a server on another host (see CANNON_URL and CANNON_TOKEN).",
+				EnvVars: []string{"CANNON_REMOTE"},
+				Action: func(ctx *cli.Context, v bool) error {
+					remote = v
+					return nil
+				},
+			},
 		},
 
 		Action: func(cCtx *cli.Context) error {
-			if cCtx.Args().Len() == 0 {
+			if cCtx.Args().Len() == 0 || (compare && cCtx.Args().Len() != 2) {
 				cli.ShowAppHelpAndExit(cCtx, 1)
 			}
 
@@ -66,7 +101,17 @@ func main() {
 			// hpos := cCtx.Args().Get(3)
 			// vpos := cCtx.Args().Get(4)
 
-			if close {
+			if compare && remote {
+				log.Printf("Error comparing files: --diff does not support --remote")
+			} else if compare {
+				// display the differences between two files
+				displayDiff(fname, cCtx.Args().Get(1), channel)
+			} else if close && remote {
+				// close the uploaded copy of the specified file
+				if params, err := remoteParams(fname, channel); err == nil {
+					server.Request("POST", "close", map[string]string{"hash": util.HashRemote(params["host"], params["file"], params["mtime"])})
+				}
+			} else if close {
 				// close the specified file
 				var hash, file string
 				var err error
@@ -85,7 +130,11 @@ func main() {
 				// display contents
 				go func() {
 					defer wg.Done()
-					displayContents(fname)
+					if remote {
+						displayRemote(fname, channel)
+					} else {
+						displayContents(fname, channel)
+					}
 				}()
 
 				// display metadata
@@ -108,8 +157,8 @@ func main() {
 
 func displayMetadata(v string) {
 	var wg sync.WaitGroup
-	wg.Add(2)
-	var mime, meta string
+	wg.Add(3)
+	var mime, meta, info string
 	go func() {
 		defer wg.Done()
 		mime = resources.GetMimeType(v)
@@ -118,12 +167,36 @@ func displayMetadata(v string) {
 		defer wg.Done()
 		meta, _ = util.GetMetadataDisplayString(v)
 	}()
+	go func() {
+		defer wg.Done()
+		if m, err := media.Probe(v); err == nil {
+			info, _ = m.DisplayString()
+		}
+	}()
 	wg.Wait()
 	fmt.Println(mime)
 	fmt.Println(meta)
+	if info != "" {
+		fmt.Println(info)
+	}
+}
+
+func displayDiff(a string, b string, channel string) {
+	// compare the specified files
+	hash, file, other, err := util.HashPathPair(a, b)
+	if err != nil {
+		log.Printf("Error generating file hash: %v", err)
+	}
+	params := map[string]string{
+		"file":    file,
+		"other":   other,
+		"hash":    hash,
+		"channel": channel,
+	}
+	server.Request("POST", "display", params)
 }
 
-func displayContents(v string) {
+func displayContents(v string, channel string) {
 	// display the specified file
 	var hash, file string
 	var err error
@@ -131,8 +204,44 @@ func displayContents(v string) {
 		log.Printf("Error generating file hash: %v", err)
 	}
 	params := map[string]string{
-		"file": file,
-		"hash": hash,
+		"file":    file,
+		"hash":    hash,
+		"channel": channel,
 	}
 	server.Request("POST", "display", params)
 }
+
+func remoteParams(v string, channel string) (map[string]string, error) {
+	// describe the file well enough for the server to tell versions apart
+	file, err := filepath.Abs(v)
+	if err != nil {
+		log.Printf("Error generating absolute path: %v", err)
+		return nil, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		log.Printf("Error reading file: %v", err)
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		log.Printf("Error reading host name: %v", err)
+		return nil, err
+	}
+	return map[string]string{
+		"host":    host,
+		"file":    file,
+		"mtime":   info.ModTime().UTC().Format(time.RFC3339Nano),
+		"size":    strconv.FormatInt(info.Size(), 10),
+		"channel": channel,
+	}, nil
+}
+
+func displayRemote(v string, channel string) {
+	// upload the specified file to the server
+	params, err := remoteParams(v, channel)
+	if err != nil {
+		return
+	}
+	server.Upload(params["file"], params)
+}
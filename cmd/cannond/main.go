@@ -6,6 +6,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/ccammack/cannon/assets"
 	"github.com/ccammack/cannon/server"
 	"github.com/urfave/cli/v2"
 )
@@ -57,6 +58,46 @@ func main() {
 					return nil
 				},
 			},
+			{
+				Name:    "status",
+				Aliases: []string{"u"},
+				Usage:   "report whether the preview server is running (exit code 0: running, 1: not responding, 3: stopped)",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the status as json",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if code := server.Status(cCtx.Bool("json")); code != server.StatusRunning {
+						return cli.Exit("", code)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "assets",
+				Usage: "manage the third-party files served from /assets/",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "fetch",
+						Usage: "download the pinned assets so previews work offline",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "download assets that already exist",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							if err := assets.Fetch(cCtx.Bool("force")); err != nil {
+								log.Printf("Error %v", err)
+								return cli.Exit("", 1)
+							}
+							return nil
+						},
+					},
+				},
+			},
 			{
 				Name:    "toggle",
 				Aliases: []string{"t"},
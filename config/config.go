@@ -5,12 +5,14 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
 	"sync"
 
 	"github.com/adrg/xdg"
+	"github.com/ccammack/cannon/builtin"
 	"github.com/ccammack/cannon/gen"
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/file"
@@ -69,6 +71,14 @@ func optionalStrings(s string, ko *koanf.Koanf) gen.Pair {
 	return gen.Pair{K: key, V: ko.Strings(key)}
 }
 
+func optionalStringMap(s string, ko *koanf.Koanf) gen.Pair {
+	key, err := key(s, ko)
+	if err != nil {
+		return gen.Pair{K: key, V: nil}
+	}
+	return gen.Pair{K: key, V: ko.StringMap(key)}
+}
+
 func ReplacePlaceholder(s, placeholder, replacement string) string {
 	return strings.ReplaceAll(s, placeholder, replacement)
 }
@@ -128,6 +138,30 @@ func Logfile() gen.Pair { return applyEnvPlaceholder("logfile", false, config) }
 func Mime() gen.Pair    { return applyEnvPlaceholders("mime", true, config) }
 func Browser() gen.Pair { return applyEnvPlaceholders("browser", false, config) }
 func Style() gen.Pair   { return applyEnvPlaceholder("style", false, config) }
+func Diff() gen.Pair    { return applyEnvPlaceholder("diff", false, config) }
+func Git() gen.Pair     { return applyEnvPlaceholder("git", false, config) }
+func Theme() gen.Pair   { return applyEnvPlaceholder("theme", false, config) }
+func Open() gen.Pair    { return applyEnvPlaceholders("open", false, config) }
+func Keys() gen.Pair    { return optionalStringMap("keys", config) }
+func Listen() gen.Pair  { return applyEnvPlaceholders("listen", false, config) }
+func Roots() gen.Pair   { return applyEnvPlaceholders("roots", false, config) }
+func Deny() gen.Pair    { return applyEnvPlaceholders("deny", false, config) }
+func TLS() gen.Pair     { return applyEnvPlaceholder("tls", false, config) }
+func TLSCert() gen.Pair { return applyEnvPlaceholder("tlscert", false, config) }
+func TLSKey() gen.Pair  { return applyEnvPlaceholder("tlskey", false, config) }
+func Upload() gen.Pair  { return applyEnvPlaceholder("upload", false, config) }
+func Assets() gen.Pair  { return applyEnvPlaceholder("assets", false, config) }
+
+func Template() gen.Pair { return applyEnvPlaceholder("template", false, config) }
+
+func Path() string {
+	return configPath
+}
+
+func Dir() string {
+	// the directory holding the config file and any files cannon caches beside it
+	return filepath.Dir(configPath)
+}
 
 type FileConversionDep struct {
 	Apps gen.Pair
@@ -158,11 +192,18 @@ func Deps() (string, []FileConversionDep) {
 }
 
 type FileConversionRule struct {
-	Ext  gen.Pair
-	Mime gen.Pair
-	Cmd  gen.Pair
-	Src  gen.Pair
-	Html gen.Pair
+	Ext      gen.Pair
+	Mime     gen.Pair
+	Cmd      gen.Pair
+	Builtin  gen.Pair
+	Src      gen.Pair
+	Html     gen.Pair
+	Pages    gen.Pair
+	Frames   gen.Pair
+	Duration gen.Pair
+	Git      gen.Pair
+	Style    gen.Pair
+	Reload   gen.Pair
 }
 
 func Rules() (string, []FileConversionRule) {
@@ -181,10 +222,17 @@ func Rules() (string, []FileConversionRule) {
 		ext := optionalStrings("ext", v)
 		mime := optionalStrings("mime", v)
 		cmd := applyEnvPlaceholders("cmd", false, v)
+		builtin := optionalString("builtin", v)
 		src := optionalString("src", v)
 		html := optionalString("html", v)
-
-		rules = append(rules, FileConversionRule{ext, mime, cmd, src, html})
+		pages := applyEnvPlaceholders("pages", false, v)
+		frames := optionalString("frames", v)
+		duration := applyEnvPlaceholders("duration", false, v)
+		git := optionalString("git", v)
+		style := optionalString("style", v)
+		reload := optionalString("reload", v)
+
+		rules = append(rules, FileConversionRule{ext, mime, cmd, builtin, src, html, pages, frames, duration, git, style, reload})
 	}
 
 	// TODO: make Rules() return a gen.Pair
@@ -192,6 +240,41 @@ func Rules() (string, []FileConversionRule) {
 	return key, rules
 }
 
+func Effective() map[string]interface{} {
+	// report the value in effect for each setting under the key it was read from
+	settings := map[string]interface{}{}
+	for _, pair := range []gen.Pair{Port(), Listen(), Timeout(), Exit(), Logfile(), Mime(), Browser(),
+		Style(), Theme(), Keys(), Open(), Diff(), Git(), Roots(), Deny(), TLS(), TLSCert(), TLSKey(), Upload(), Assets(), Template()} {
+		settings[pair.K] = pair.V
+	}
+
+	rulesk, rulesv := Rules()
+	rules := []map[string]interface{}{}
+	for _, rule := range rulesv {
+		fields := map[string]interface{}{}
+		for _, pair := range []gen.Pair{rule.Ext, rule.Mime, rule.Cmd, rule.Builtin, rule.Src, rule.Html,
+			rule.Pages, rule.Frames, rule.Duration, rule.Git, rule.Style, rule.Reload} {
+			if pair.V != nil && pair.V != "" && !isEmptyStrings(pair.V) {
+				fields[pair.K] = pair.V
+			}
+		}
+		rules = append(rules, fields)
+	}
+	if rulesk != "" {
+		settings[rulesk] = rules
+	}
+
+	return map[string]interface{}{
+		"path":     configPath,
+		"settings": settings,
+	}
+}
+
+func isEmptyStrings(v interface{}) bool {
+	strings, ok := v.([]string)
+	return ok && len(strings) == 0
+}
+
 func RegisterCallback(callback func(string)) {
 	callbacks = append(callbacks, callback)
 }
@@ -237,6 +320,15 @@ func Validate() {
 		requiredExe(browser[0])
 	}
 
+	// make sure the builtin converters named by the rules exist
+	rulesk, rulesv := Rules()
+	for idx, rule := range rulesv {
+		builtink, builtinv := rule.Builtin.String()
+		if _, ok := builtin.Lookup(builtinv); builtinv != "" && !ok {
+			log.Printf("Error finding %s[%d].%s[%s]: available converters are %v", rulesk, idx, builtink, builtinv, builtin.Names())
+		}
+	}
+
 	// validate the specified deps
 	depsk, depsv := Deps()
 	for idx, rule := range depsv {
@@ -297,5 +389,10 @@ func Watch() {
 		configLock.Unlock()
 
 		postLoad()
+
+		// let subscribers read the new values
+		for _, callback := range callbacks {
+			callback("reloaded")
+		}
 	})
 }
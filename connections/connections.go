@@ -6,17 +6,72 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"time"
 
+	"github.com/ccammack/cannon/metrics"
 	"github.com/gorilla/websocket"
 )
 
+type viewer struct {
+	remote  string
+	agent   string
+	since   time.Time
+	channel string
+	state   *State
+	out     chan interface{}
+	done    chan struct{}
+}
+
+const (
+	// messages waiting for a slow viewer before it is dropped
+	queueSize = 64
+
+	// how long one write may take before the viewer is dropped
+	writeTimeout = 10 * time.Second
+)
+
+// values kept by the handlers for one viewer between messages
+type State struct {
+	values map[string]interface{}
+	mu     sync.Mutex
+}
+
+func (s *State) Get(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+func (s *State) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+func (s *State) Update(key string, fn func(value interface{}) interface{}) interface{} {
+	// replace a value based on the current one without racing other messages
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = fn(s.values[key])
+	return s.values[key]
+}
+
 var lock sync.RWMutex
-var connections = make(map[*websocket.Conn]bool)
+var connections = make(map[*websocket.Conn]*viewer)
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// handlers for actions sent by the browser; reply sends a message back to the same viewer
+// the connect handler runs once when a viewer connects, with the query parameters of its url
+var handlers = make(map[string]func(reply func(interface{}), state *State, data map[string]interface{}))
+
+// the default origin check only accepts pages served by cannond itself
+var upgrader = websocket.Upgrader{}
+
+func init() {
+	metrics.NewGaugeFunc("cannon_websocket_connections", "Browsers connected over websockets.", func() float64 {
+		lock.RLock()
+		defer lock.RUnlock()
+		return float64(len(connections))
+	})
 }
 
 func New(w http.ResponseWriter, r *http.Request) error {
@@ -31,17 +86,32 @@ func New(w http.ResponseWriter, r *http.Request) error {
 
 	lock.Lock()
 	defer lock.Unlock()
-	connections[c] = true
-	go receive(c)
+	v := &viewer{r.RemoteAddr, r.UserAgent(), time.Now(), r.URL.Query().Get("channel"), &State{values: map[string]interface{}{}}, make(chan interface{}, queueSize), make(chan struct{})}
+	connections[c] = v
+	go receive(c, v.state)
+	go write(c, v)
+
+	// let the connect handler greet the new viewer
+	if handler, ok := handlers["connect"]; ok {
+		data := map[string]interface{}{}
+		for key := range r.URL.Query() {
+			data[key] = r.URL.Query().Get(key)
+		}
+		data["channel"] = v.channel
+		go handler(func(message interface{}) { send(c, message) }, v.state, data)
+	}
 	return nil
 }
 
-func receive(c *websocket.Conn) {
+func receive(c *websocket.Conn, state *State) {
 	defer func() {
 		lock.Lock()
 		defer lock.Unlock()
 		c.Close()
-		delete(connections, c)
+		if v, ok := connections[c]; ok {
+			close(v.out)
+			delete(connections, c)
+		}
 	}()
 
 	for {
@@ -62,17 +132,105 @@ func receive(c *websocket.Conn) {
 		if ok && value == "close" {
 			break
 		}
+
+		// dispatch other actions to their registered handler
+		if action, ok := value.(string); ok {
+			if handler, ok := handlers[action]; ok {
+				go handler(func(message interface{}) { send(c, message) }, state, data)
+			}
+		}
+	}
+}
+
+func Handle(action string, handler func(reply func(interface{}), state *State, data map[string]interface{})) {
+	handlers[action] = handler
+}
+
+func write(c *websocket.Conn, v *viewer) {
+	// write queued messages in order so a stalled viewer only holds up itself
+	defer close(v.done)
+	for message := range v.out {
+		c.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := c.WriteJSON(message); err != nil {
+			log.Printf("error sending message: %v", err)
+
+			// closing makes receive drop the connection; discard the rest
+			c.Close()
+			for range v.out {
+			}
+			return
+		}
+	}
+}
+
+func enqueue(c *websocket.Conn, v *viewer, message interface{}) {
+	// called with the lock held; never wait on a viewer
+	select {
+	case v.out <- message:
+	default:
+		log.Printf("error sending message: %s is not keeping up", v.remote)
+		c.Close()
+	}
+}
+
+func send(c *websocket.Conn, message interface{}) {
+	// send message to one client
+	lock.RLock()
+	defer lock.RUnlock()
+	if v, ok := connections[c]; ok {
+		enqueue(c, v, message)
 	}
 }
 
 func Broadcast(message interface{}) {
 	// send message to the clients
+	lock.RLock()
+	defer lock.RUnlock()
+	for c, v := range connections {
+		enqueue(c, v, message)
+	}
+}
+
+func Each(fn func(channel string, state *State, reply func(interface{}))) {
+	// visit every viewer; reply queues a message for the one being visited
+	lock.RLock()
+	defer lock.RUnlock()
+	for c, v := range connections {
+		c, v := c, v
+		fn(v.channel, v.state, func(message interface{}) { enqueue(c, v, message) })
+	}
+}
+
+func Viewers() []map[string]interface{} {
+	// describe the connected browsers
+	lock.RLock()
+	defer lock.RUnlock()
+	viewers := []map[string]interface{}{}
+	for _, v := range connections {
+		viewers = append(viewers, map[string]interface{}{
+			"remote":    v.remote,
+			"userAgent": v.agent,
+			"since":     v.since,
+			"channel":   v.channel,
+		})
+	}
+	return viewers
+}
+
+func Close() {
+	// say goodbye to every viewer and drop the connections
 	lock.Lock()
 	defer lock.Unlock()
-	for c := range connections {
-		err := c.WriteJSON(message)
-		if err != nil {
-			log.Printf("error sending message: %v", err)
+	deadline := time.Now().Add(time.Second)
+	for c, v := range connections {
+		// let the queued messages go out first
+		close(v.out)
+		select {
+		case <-v.done:
+		case <-time.After(time.Until(deadline)):
 		}
+		c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server stopped"), deadline)
+		c.Close()
+		delete(connections, c)
 	}
 }
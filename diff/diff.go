@@ -0,0 +1,136 @@
+package diff
+
+// Myers' O(ND) difference algorithm over arbitrary comparable sequences
+
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+type Edit struct {
+	Op Op
+	A  int // index into a for Equal and Delete
+	B  int // index into b for Equal and Insert
+}
+
+// give up on a minimal diff past this many differences and fall back to replacing the middle
+const maxDifferences = 1024
+
+func Compute[T comparable](a, b []T) []Edit {
+	// trim the common prefix and suffix
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := []Edit{}
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, Edit{Equal, i, i})
+	}
+	edits = append(edits, middle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], prefix, prefix)...)
+	for i := suffix; i > 0; i-- {
+		edits = append(edits, Edit{Equal, len(a) - i, len(b) - i})
+	}
+	return edits
+}
+
+func replace(n, m, offA, offB int) []Edit {
+	edits := []Edit{}
+	for i := 0; i < n; i++ {
+		edits = append(edits, Edit{Delete, offA + i, offB})
+	}
+	for j := 0; j < m; j++ {
+		edits = append(edits, Edit{Insert, offA + n, offB + j})
+	}
+	return edits
+}
+
+func middle[T comparable](a, b []T, offA, offB int) []Edit {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replace(n, m, offA, offB)
+	}
+
+	// record the furthest reaching path for each diagonal k = x - y
+	max := n + m
+	if max > maxDifferences {
+		max = maxDifferences
+	}
+	v := make([]int, 2*max+2)
+	trace := [][]int{}
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		snapshot := make([]int, len(v))
+		copy(snapshot, v)
+		trace = append(trace, snapshot)
+	}
+	if !found {
+		return replace(n, m, offA, offB)
+	}
+
+	// walk the trace backwards to recover the edit script
+	reversed := []Edit{}
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		k := x - y
+		var prevK int
+		if d == 0 {
+			prevK = 0
+		} else if k == -d || (k != d && trace[d-1][max+k-1] < trace[d-1][max+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = trace[d-1][max+prevK]
+		}
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, Edit{Equal, offA + x, offB + y})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				reversed = append(reversed, Edit{Insert, offA + x, offB + y})
+			} else {
+				x--
+				reversed = append(reversed, Edit{Delete, offA + x, offB + y})
+			}
+		}
+	}
+
+	edits := make([]Edit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+	return edits
+}
@@ -0,0 +1,240 @@
+package diff
+
+// render line differences as side-by-side or unified html tables
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// number of unchanged lines to keep around each change
+const contextLines = 3
+
+const Style = `
+	table.diff { border-collapse: collapse; width: 100%; font-family: monospace; font-size: small; }
+	table.diff th { text-align: left; padding: 2px 4px; }
+	table.diff td { white-space: pre-wrap; word-break: break-all; vertical-align: top; padding: 0 4px; }
+	table.diff td.num { color: #888; text-align: right; user-select: none; width: 1%; white-space: nowrap; }
+	table.diff td.del { background: #ffecec; }
+	table.diff td.ins { background: #eaffea; }
+	table.diff td.del span.ch { background: #f8b4b4; }
+	table.diff td.ins span.ch { background: #a6f0a6; }
+	table.diff tr.gap td { text-align: center; color: #888; background: #f4f4f4; }
+`
+
+// a pair of lines displayed on one side-by-side row; -1 marks a missing side
+type pair struct {
+	op   Op
+	a, b int
+}
+
+func pairs(edits []Edit) []pair {
+	rows := []pair{}
+	dels, ins := []int{}, []int{}
+	flush := func() {
+		for i := 0; i < len(dels) || i < len(ins); i++ {
+			p := pair{Delete, -1, -1}
+			if i < len(dels) {
+				p.a = dels[i]
+			}
+			if i < len(ins) {
+				p.b = ins[i]
+			}
+			if p.a < 0 {
+				p.op = Insert
+			}
+			rows = append(rows, p)
+		}
+		dels, ins = dels[:0], ins[:0]
+	}
+	for _, e := range edits {
+		switch e.Op {
+		case Equal:
+			flush()
+			rows = append(rows, pair{Equal, e.A, e.B})
+		case Delete:
+			dels = append(dels, e.A)
+		case Insert:
+			ins = append(ins, e.B)
+		}
+	}
+	flush()
+	return rows
+}
+
+func visible(rows []pair) []bool {
+	// keep changes and the unchanged lines near them
+	show := make([]bool, len(rows))
+	for i, row := range rows {
+		if row.op == Equal {
+			continue
+		}
+		for j := i - contextLines; j <= i+contextLines; j++ {
+			if j >= 0 && j < len(rows) {
+				show[j] = true
+			}
+		}
+	}
+	return show
+}
+
+func highlight(x, y string) (string, string) {
+	// mark the changed characters within a pair of modified lines
+	rx, ry := []rune(x), []rune(y)
+	var bx, by strings.Builder
+	openX, openY := false, false
+	for _, e := range Compute(rx, ry) {
+		switch e.Op {
+		case Equal:
+			if openX {
+				bx.WriteString(`</span>`)
+				openX = false
+			}
+			if openY {
+				by.WriteString(`</span>`)
+				openY = false
+			}
+			bx.WriteString(template.HTMLEscapeString(string(rx[e.A])))
+			by.WriteString(template.HTMLEscapeString(string(ry[e.B])))
+		case Delete:
+			if !openX {
+				bx.WriteString(`<span class="ch">`)
+				openX = true
+			}
+			bx.WriteString(template.HTMLEscapeString(string(rx[e.A])))
+		case Insert:
+			if !openY {
+				by.WriteString(`<span class="ch">`)
+				openY = true
+			}
+			by.WriteString(template.HTMLEscapeString(string(ry[e.B])))
+		}
+	}
+	if openX {
+		bx.WriteString(`</span>`)
+	}
+	if openY {
+		by.WriteString(`</span>`)
+	}
+	return bx.String(), by.String()
+}
+
+func cells(a, b []string, row pair) (string, string) {
+	x, y := "", ""
+	if row.a >= 0 {
+		x = template.HTMLEscapeString(a[row.a])
+	}
+	if row.b >= 0 {
+		y = template.HTMLEscapeString(b[row.b])
+	}
+	if row.op == Delete && row.a >= 0 && row.b >= 0 {
+		x, y = highlight(a[row.a], b[row.b])
+	}
+	return x, y
+}
+
+func number(i int) string {
+	if i < 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", i+1)
+}
+
+func Html(nameA, nameB string, a, b []string, unified bool) string {
+	rows := pairs(Compute(a, b))
+	show := visible(rows)
+
+	changed := false
+	for _, row := range rows {
+		changed = changed || row.op != Equal
+	}
+
+	var out strings.Builder
+	out.WriteString(`<style>` + Style + `</style>`)
+	if !changed {
+		out.WriteString(`<p>Files are identical: ` + template.HTMLEscapeString(nameA) + ` ` + template.HTMLEscapeString(nameB) + `</p>`)
+		return out.String()
+	}
+
+	columns := 4
+	if unified {
+		columns = 3
+		out.WriteString(`<table class="diff unified"><tr><th colspan="3">--- ` + template.HTMLEscapeString(nameA) + `<br>+++ ` + template.HTMLEscapeString(nameB) + `</th></tr>`)
+	} else {
+		out.WriteString(`<table class="diff split"><tr><th colspan="2">` + template.HTMLEscapeString(nameA) + `</th><th colspan="2">` + template.HTMLEscapeString(nameB) + `</th></tr>`)
+	}
+
+	gap := false
+	for i := 0; i < len(rows); i++ {
+		if !show[i] {
+			if !gap {
+				out.WriteString(fmt.Sprintf(`<tr class="gap"><td colspan="%d">&#8943;</td></tr>`, columns))
+				gap = true
+			}
+			continue
+		}
+		gap = false
+
+		if !unified {
+			row := rows[i]
+			x, y := cells(a, b, row)
+			classA, classB := "", ""
+			if row.op != Equal {
+				classA, classB = ` class="del"`, ` class="ins"`
+			}
+			out.WriteString(`<tr><td class="num">` + number(row.a) + `</td><td` + classA + `>` + x + `</td>`)
+			out.WriteString(`<td class="num">` + number(row.b) + `</td><td` + classB + `>` + y + `</td></tr>`)
+			continue
+		}
+
+		// unified output lists a block of removed lines before the added ones
+		if rows[i].op == Equal {
+			x, _ := cells(a, b, rows[i])
+			out.WriteString(`<tr><td class="num">` + number(rows[i].a) + `</td><td class="num">` + number(rows[i].b) + `</td><td> ` + x + `</td></tr>`)
+			continue
+		}
+		j := i
+		for j < len(rows) && rows[j].op != Equal {
+			j++
+		}
+		added := []string{}
+		for _, row := range rows[i:j] {
+			x, y := cells(a, b, row)
+			if row.a >= 0 {
+				out.WriteString(`<tr><td class="num">` + number(row.a) + `</td><td class="num"></td><td class="del">-` + x + `</td></tr>`)
+			}
+			if row.b >= 0 {
+				added = append(added, `<tr><td class="num"></td><td class="num">`+number(row.b)+`</td><td class="ins">+`+y+`</td></tr>`)
+			}
+		}
+		out.WriteString(strings.Join(added, ""))
+		i = j - 1
+	}
+	out.WriteString(`</table>`)
+
+	return out.String()
+}
+
+func Patch(patch string) string {
+	// render the unified output of an external diff tool such as git diff
+	var out strings.Builder
+	out.WriteString(`<style>` + Style + `</style><table class="diff unified">`)
+	for _, line := range strings.Split(strings.TrimSuffix(patch, "\n"), "\n") {
+		text := template.HTMLEscapeString(line)
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			out.WriteString(`<tr class="gap"><td>` + text + `</td></tr>`)
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			out.WriteString(`<tr><th>` + text + `</th></tr>`)
+		case strings.HasPrefix(line, "+"):
+			out.WriteString(`<tr><td class="ins">` + text + `</td></tr>`)
+		case strings.HasPrefix(line, "-"):
+			out.WriteString(`<tr><td class="del">` + text + `</td></tr>`)
+		case strings.HasPrefix(line, " "), strings.HasPrefix(line, `\`), strings.HasPrefix(line, "Binary files"):
+			out.WriteString(`<tr><td>` + text + `</td></tr>`)
+		}
+	}
+	out.WriteString(`</table>`)
+	return out.String()
+}
@@ -39,3 +39,22 @@ func (p Pair) Strings() (string, []string) {
 	}
 	return p.K, nil
 }
+
+func (p Pair) StringMap() (string, map[string]string) {
+	if m, ok := p.V.(map[string]string); ok {
+		return p.K, m
+	}
+	return p.K, nil
+}
+
+func (p Pair) Bool() (string, bool) {
+	if b, ok := p.V.(bool); ok {
+		return p.K, b
+	} else if s, ok := p.V.(string); ok {
+		b, err := strconv.ParseBool(s)
+		if err == nil {
+			return p.K, b
+		}
+	}
+	return p.K, false
+}
@@ -0,0 +1,149 @@
+package media
+
+// flac streams and the vorbis comments shared with ogg
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+func parseFLAC(r io.Reader, info *Info) error {
+	info.Format = "flac"
+	info.addCodec("flac")
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return err
+	}
+
+	// walk the metadata blocks that precede the audio frames
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return err
+		}
+		last := header[0]&0x80 != 0
+		kind := header[0] & 0x7f
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		if length > maxBoxLength {
+			return errors.New("flac metadata block too large")
+		}
+		block := make([]byte, length)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return err
+		}
+
+		switch kind {
+		case 0:
+			parseStreamInfo(block, info)
+		case 4:
+			parseVorbisComment(block, info)
+		case 6:
+			parseFLACPicture(block, info)
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+func parseStreamInfo(block []byte, info *Info) {
+	if len(block) < 18 {
+		return
+	}
+	// 20 bits sample rate, 3 bits channels, 5 bits depth, 36 bits total samples
+	bits := binary.BigEndian.Uint64(block[10:18])
+	info.SampleRate = int(bits >> 44)
+	info.Channels = int((bits>>41)&7) + 1
+	samples := bits & 0xfffffffff
+	if info.SampleRate > 0 && samples > 0 {
+		info.Duration = time.Duration(float64(samples) / float64(info.SampleRate) * float64(time.Second))
+	}
+}
+
+func parseVorbisComment(block []byte, info *Info) {
+	// little-endian vendor string followed by a list of KEY=value strings
+	next := func() (string, bool) {
+		if len(block) < 4 {
+			return "", false
+		}
+		length := int(binary.LittleEndian.Uint32(block[0:4]))
+		if length < 0 || 4+length > len(block) {
+			return "", false
+		}
+		s := string(block[4 : 4+length])
+		block = block[4+length:]
+		return s, true
+	}
+
+	if _, ok := next(); !ok {
+		return
+	}
+	if len(block) < 4 {
+		return
+	}
+	count := int(binary.LittleEndian.Uint32(block[0:4]))
+	block = block[4:]
+	for i := 0; i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			return
+		}
+		key, value, found := strings.Cut(comment, "=")
+		if !found {
+			continue
+		}
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			info.Title = value
+		case "ARTIST":
+			info.Artist = value
+		case "METADATA_BLOCK_PICTURE":
+			if picture, err := base64.StdEncoding.DecodeString(value); err == nil {
+				parseFLACPicture(picture, info)
+			}
+		}
+	}
+}
+
+func parseFLACPicture(block []byte, info *Info) {
+	// picture type, mime, description, dimensions, depth, colors, data
+	field := func() ([]byte, bool) {
+		if len(block) < 4 {
+			return nil, false
+		}
+		length := int(binary.BigEndian.Uint32(block[0:4]))
+		if length < 0 || 4+length > len(block) {
+			return nil, false
+		}
+		value := block[4 : 4+length]
+		block = block[4+length:]
+		return value, true
+	}
+
+	if len(block) < 4 {
+		return
+	}
+	block = block[4:]
+	mime, ok := field()
+	if !ok {
+		return
+	}
+	if _, ok := field(); !ok {
+		return
+	}
+	if len(block) < 16 {
+		return
+	}
+	block = block[16:]
+	data, ok := field()
+	if !ok {
+		return
+	}
+	info.setCover(string(mime), data)
+}
@@ -0,0 +1,262 @@
+package media
+
+// matroska and webm (EBML)
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	ebmlHeader      = 0x1a45dfa3
+	ebmlDocType     = 0x4282
+	mkvSegment      = 0x18538067
+	mkvInfo         = 0x1549a966
+	mkvTimecode     = 0x2ad7b1
+	mkvDuration     = 0x4489
+	mkvTitle        = 0x7ba9
+	mkvTracks       = 0x1654ae6b
+	mkvTrackEntry   = 0xae
+	mkvCodecID      = 0x86
+	mkvVideo        = 0xe0
+	mkvPixelWidth   = 0xb0
+	mkvPixelHeight  = 0xba
+	mkvAudio        = 0xe1
+	mkvSampling     = 0xb5
+	mkvChannels     = 0x9f
+	mkvTags         = 0x1254c367
+	mkvTag          = 0x7373
+	mkvSimpleTag    = 0x67c8
+	mkvTagName      = 0x45a3
+	mkvTagString    = 0x4487
+	mkvAttachments  = 0x1941a469
+	mkvAttachedFile = 0x61a7
+	mkvFileName     = 0x466e
+	mkvFileMime     = 0x4660
+	mkvFileData     = 0x465c
+	mkvCluster      = 0x1f43b675
+)
+
+// marks an element whose size is not recorded in the header
+const ebmlUnknownSize = -1
+
+type ebmlReader struct {
+	r   io.ReadSeeker
+	pos int64
+}
+
+func (e *ebmlReader) vint(keepMarker bool) (int64, int, error) {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(e.r, first); err != nil {
+		return 0, 0, err
+	}
+	length := 1
+	for mask := byte(0x80); length <= 8 && first[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, errors.New("invalid ebml variable length integer")
+	}
+
+	rest := make([]byte, length-1)
+	if _, err := io.ReadFull(e.r, rest); err != nil {
+		return 0, 0, err
+	}
+	e.pos += int64(length)
+
+	value := int64(first[0])
+	if !keepMarker {
+		value &= int64(0xff >> length)
+	}
+	allOnes := value == int64(0xff>>length)
+	for _, b := range rest {
+		value = value<<8 | int64(b)
+		allOnes = allOnes && b == 0xff
+	}
+	if !keepMarker && allOnes {
+		return ebmlUnknownSize, length, nil
+	}
+	return value, length, nil
+}
+
+func (e *ebmlReader) element() (int64, int64, error) {
+	id, _, err := e.vint(true)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, _, err := e.vint(false)
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, size, nil
+}
+
+func (e *ebmlReader) seek(pos int64) error {
+	_, err := e.r.Seek(pos, io.SeekStart)
+	e.pos = pos
+	return err
+}
+
+func (e *ebmlReader) bytes(size int64) ([]byte, error) {
+	if size < 0 || size > maxBoxLength {
+		return nil, errors.New("ebml element too large")
+	}
+	data := make([]byte, size)
+	_, err := io.ReadFull(e.r, data)
+	e.pos += size
+	return data, err
+}
+
+func ebmlUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+type matroskaParser struct {
+	e        *ebmlReader
+	info     *Info
+	timecode uint64
+	duration float64
+	tagName  string
+	fileName string
+	fileMime string
+}
+
+func parseMatroska(r io.ReadSeeker, size int64, info *Info) error {
+	p := &matroskaParser{e: &ebmlReader{r: r}, info: info, timecode: 1000000}
+	info.Format = "matroska"
+	if err := p.walk(size, 0); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if p.duration > 0 {
+		info.Duration = time.Duration(p.duration * float64(p.timecode))
+	}
+	return nil
+}
+
+func (p *matroskaParser) walk(end int64, depth int) error {
+	if depth > 8 {
+		return errors.New("ebml elements nested too deeply")
+	}
+
+	for p.e.pos < end {
+		id, size, err := p.e.element()
+		if err != nil {
+			return err
+		}
+		body := p.e.pos
+		next := body + size
+		if size == ebmlUnknownSize {
+			if id != mkvSegment {
+				// cannot skip an element of unknown length
+				return nil
+			}
+			next = end
+		}
+
+		switch id {
+		case ebmlHeader, mkvSegment, mkvInfo, mkvTracks, mkvTrackEntry, mkvVideo, mkvAudio, mkvTags, mkvTag, mkvAttachments:
+			if err := p.walk(next, depth+1); err != nil {
+				return err
+			}
+		case mkvSimpleTag:
+			p.tagName = ""
+			if err := p.walk(next, depth+1); err != nil {
+				return err
+			}
+		case mkvAttachedFile:
+			p.fileName, p.fileMime = "", ""
+			if err := p.walk(next, depth+1); err != nil {
+				return err
+			}
+		case mkvCluster:
+			// skip media data
+		case mkvFileData:
+			name := strings.ToLower(p.fileName)
+			if strings.HasPrefix(p.fileMime, "image/") && size <= maxCoverLength && (strings.HasPrefix(name, "cover") || len(p.info.Cover) == 0) {
+				data, err := p.e.bytes(size)
+				if err != nil {
+					return err
+				}
+				p.info.Cover = nil
+				p.info.setCover(p.fileMime, data)
+			}
+		case ebmlDocType, mkvTimecode, mkvDuration, mkvTitle, mkvCodecID, mkvPixelWidth, mkvPixelHeight,
+			mkvSampling, mkvChannels, mkvTagName, mkvTagString, mkvFileName, mkvFileMime:
+			data, err := p.e.bytes(size)
+			if err != nil {
+				return err
+			}
+			p.leaf(id, data)
+		}
+
+		if err := p.e.seek(next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *matroskaParser) leaf(id int64, data []byte) {
+	text := strings.TrimRight(string(data), "\x00")
+	switch id {
+	case ebmlDocType:
+		p.info.Format = text
+	case mkvTimecode:
+		p.timecode = ebmlUint(data)
+	case mkvDuration:
+		p.duration = ebmlFloat(data)
+	case mkvTitle:
+		p.info.Title = text
+	case mkvCodecID:
+		p.info.addCodec(text)
+	case mkvPixelWidth:
+		if width := int(ebmlUint(data)); width > p.info.Width {
+			p.info.Width = width
+		}
+	case mkvPixelHeight:
+		if height := int(ebmlUint(data)); height > p.info.Height {
+			p.info.Height = height
+		}
+	case mkvSampling:
+		if p.info.SampleRate == 0 {
+			p.info.SampleRate = int(ebmlFloat(data))
+		}
+	case mkvChannels:
+		if p.info.Channels == 0 {
+			p.info.Channels = int(ebmlUint(data))
+		}
+	case mkvTagName:
+		p.tagName = strings.ToUpper(text)
+	case mkvTagString:
+		switch p.tagName {
+		case "TITLE":
+			if p.info.Title == "" {
+				p.info.Title = text
+			}
+		case "ARTIST":
+			p.info.Artist = text
+		}
+	case mkvFileName:
+		p.fileName = text
+	case mkvFileMime:
+		p.fileMime = text
+	}
+}
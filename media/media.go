@@ -0,0 +1,213 @@
+package media
+
+// built-in container metadata parsers for common audio and video formats
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// refuse to inline huge cover images into the page
+const maxCoverLength = 2 * 1024 * 1024
+
+var ErrUnknownFormat = errors.New("unknown media format")
+
+type Info struct {
+	Format     string
+	Duration   time.Duration
+	Codecs     []string
+	Width      int
+	Height     int
+	Bitrate    int // bits per second
+	SampleRate int
+	Channels   int
+	Title      string
+	Artist     string
+	Cover      []byte
+	CoverMime  string
+}
+
+func (info *Info) addCodec(codec string) {
+	codec = strings.TrimSpace(strings.TrimRight(codec, "\x00"))
+	if codec == "" {
+		return
+	}
+	for _, c := range info.Codecs {
+		if c == codec {
+			return
+		}
+	}
+	info.Codecs = append(info.Codecs, codec)
+}
+
+func (info *Info) setCover(mime string, data []byte) {
+	// keep the first picture found
+	if len(info.Cover) != 0 || len(data) == 0 {
+		return
+	}
+	if mime == "" || !strings.Contains(mime, "/") {
+		mime = sniffImageMime(data)
+	}
+	info.Cover = data
+	info.CoverMime = mime
+}
+
+func sniffImageMime(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG")):
+		return "image/png"
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return "image/gif"
+	case len(data) > 12 && bytes.Equal(data[8:12], []byte("WEBP")):
+		return "image/webp"
+	}
+	return "image/jpeg"
+}
+
+func Probe(file string) (*Info, error) {
+	fp, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	stat, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, ErrUnknownFormat
+	}
+	size := stat.Size()
+
+	magic := make([]byte, 12)
+	n, _ := io.ReadFull(fp, magic)
+	magic = magic[:n]
+	if _, err := fp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// select a parser using the file signature
+	info := &Info{}
+	ext := strings.ToLower(strings.TrimLeft(filepath.Ext(file), "."))
+	switch {
+	case len(magic) >= 8 && string(magic[4:8]) == "ftyp":
+		err = parseMP4(fp, size, info)
+	case bytes.HasPrefix(magic, []byte("\x1a\x45\xdf\xa3")):
+		err = parseMatroska(fp, size, info)
+	case bytes.HasPrefix(magic, []byte("fLaC")):
+		err = parseFLAC(fp, info)
+	case bytes.HasPrefix(magic, []byte("OggS")):
+		err = parseOgg(fp, size, info)
+	case bytes.HasPrefix(magic, []byte("ID3")):
+		err = parseMP3(fp, size, info)
+	case (ext == "mp3" || ext == "mp2" || ext == "mpga") && len(magic) >= 2 && magic[0] == 0xff && magic[1]&0xe0 == 0xe0:
+		// bare mpeg audio without an id3 tag
+		err = parseMP3(fp, size, info)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// estimate the overall bitrate when the container does not record one
+	if info.Bitrate == 0 && info.Duration > 0 {
+		info.Bitrate = int(float64(size*8) / info.Duration.Seconds())
+	}
+
+	return info, nil
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+func (info *Info) fields() [][2]string {
+	fields := [][2]string{}
+	add := func(k, v string) {
+		if v != "" {
+			fields = append(fields, [2]string{k, v})
+		}
+	}
+	add("Title", info.Title)
+	add("Artist", info.Artist)
+	add("Format", info.Format)
+	if info.Duration > 0 {
+		add("Duration", formatDuration(info.Duration))
+	}
+	add("Codecs", strings.Join(info.Codecs, ", "))
+	if info.Width > 0 && info.Height > 0 {
+		add("Resolution", fmt.Sprintf("%dx%d", info.Width, info.Height))
+	}
+	if info.Bitrate > 0 {
+		add("Bitrate", fmt.Sprintf("%d kb/s", info.Bitrate/1000))
+	}
+	if info.SampleRate > 0 {
+		add("Sample rate", fmt.Sprintf("%d Hz", info.SampleRate))
+	}
+	if info.Channels > 0 {
+		add("Channels", fmt.Sprintf("%d", info.Channels))
+	}
+	return fields
+}
+
+func (info *Info) HTML() string {
+	// render a summary table with the cover art to display above the player
+	var b strings.Builder
+	b.WriteString(`<div class="media-info">`)
+	if len(info.Cover) > 0 && len(info.Cover) <= maxCoverLength {
+		b.WriteString(`<img class="media-cover" src="data:` + template.HTMLEscapeString(info.CoverMime) + `;base64,`)
+		b.WriteString(base64.StdEncoding.EncodeToString(info.Cover))
+		b.WriteString(`">`)
+	}
+	b.WriteString(`<table>`)
+	for _, field := range info.fields() {
+		b.WriteString(`<tr><th>` + template.HTMLEscapeString(field[0]) + `</th><td>` + template.HTMLEscapeString(field[1]) + `</td></tr>`)
+	}
+	b.WriteString(`</table></div>`)
+	return b.String()
+}
+
+func (info *Info) DisplayString() (string, error) {
+	m := map[string]interface{}{
+		"Format":   info.Format,
+		"Duration": info.Duration.Seconds(),
+		"Codecs":   info.Codecs,
+		"Width":    info.Width,
+		"Height":   info.Height,
+		"Bitrate":  info.Bitrate,
+		"Title":    info.Title,
+		"Artist":   info.Artist,
+	}
+	if info.SampleRate > 0 {
+		m["SampleRate"] = info.SampleRate
+		m["Channels"] = info.Channels
+	}
+	if len(info.Cover) > 0 {
+		m["Cover"] = fmt.Sprintf("%s (%d bytes)", info.CoverMime, len(info.Cover))
+	}
+	bytes, err := json.MarshalIndent(m, "", " ")
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
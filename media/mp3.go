@@ -0,0 +1,277 @@
+package media
+
+// mpeg audio with optional ID3v2 tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+var (
+	mpegBitrates = map[int][16]int{
+		// mpeg version 1: layers 1, 2, 3
+		11: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		12: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		13: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		// mpeg version 2 and 2.5: layers 1, 2, 3
+		21: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		22: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		23: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	}
+	mpegSampleRates = map[int][3]int{
+		1: {44100, 48000, 32000},
+		2: {22050, 24000, 16000},
+		3: {11025, 12000, 8000},
+	}
+)
+
+func syncsafe(b []byte) int {
+	value := 0
+	for _, c := range b {
+		value = value<<7 | int(c&0x7f)
+	}
+	return value
+}
+
+func parseMP3(r io.ReadSeeker, size int64, info *Info) error {
+	info.Format = "mp3"
+
+	// read the id3v2 tag if present
+	audioStart := int64(0)
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if string(header[0:3]) == "ID3" {
+		tagSize := int64(syncsafe(header[6:10]))
+		audioStart = 10 + tagSize
+		if header[5]&0x10 != 0 {
+			// footer present
+			audioStart += 10
+		}
+		if tagSize <= maxBoxLength {
+			tag := make([]byte, tagSize)
+			if _, err := io.ReadFull(r, tag); err == nil {
+				parseID3Frames(tag, int(header[3]), info)
+			}
+		}
+	}
+
+	// find the first audio frame after the tag
+	if _, err := r.Seek(audioStart, io.SeekStart); err != nil {
+		return err
+	}
+	buf := make([]byte, 64*1024)
+	n, _ := io.ReadFull(r, buf)
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		if parseMPEGFrame(buf[i:], size-audioStart-int64(i), info) {
+			return nil
+		}
+	}
+
+	if info.Title == "" && info.Artist == "" && info.Duration == 0 {
+		return errors.New("no mpeg audio frames found")
+	}
+	return nil
+}
+
+func parseMPEGFrame(frame []byte, audioLength int64, info *Info) bool {
+	h := binary.BigEndian.Uint32(frame[0:4])
+	version := map[uint32]int{0: 3, 2: 2, 3: 1}[(h>>19)&3] // 0 = reserved
+	layer := map[uint32]int{1: 3, 2: 2, 3: 1}[(h>>17)&3]   // 0 = reserved
+	bitrateIdx := int((h >> 12) & 0xf)
+	rateIdx := int((h >> 10) & 3)
+	mono := (h>>6)&3 == 3
+	if version == 0 || layer == 0 || bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+		return false
+	}
+
+	table := 10*choose(version == 1, 1, 2) + layer
+	bitrate := mpegBitrates[table][bitrateIdx] * 1000
+	sampleRate := mpegSampleRates[version][rateIdx]
+
+	samplesPerFrame := 1152
+	if layer == 1 {
+		samplesPerFrame = 384
+	} else if layer == 3 && version != 1 {
+		samplesPerFrame = 576
+	}
+
+	info.SampleRate = sampleRate
+	info.Channels = choose(mono, 1, 2)
+	info.addCodec("mp" + strconv.Itoa(layer))
+
+	// prefer the frame count from a xing/info header for variable bitrates
+	sideInfo := 32
+	if version == 1 && mono || version != 1 && !mono {
+		sideInfo = 17
+	} else if version != 1 && mono {
+		sideInfo = 9
+	}
+	if xing := frame[choose(4+sideInfo < len(frame), 4+sideInfo, len(frame)):]; len(xing) >= 12 && (string(xing[0:4]) == "Xing" || string(xing[0:4]) == "Info") {
+		flags := binary.BigEndian.Uint32(xing[4:8])
+		if flags&1 != 0 {
+			frames := binary.BigEndian.Uint32(xing[8:12])
+			seconds := float64(frames) * float64(samplesPerFrame) / float64(sampleRate)
+			if info.Duration == 0 {
+				info.Duration = time.Duration(seconds * float64(time.Second))
+			}
+			if seconds > 0 {
+				info.Bitrate = int(float64(audioLength*8) / seconds)
+			}
+			return true
+		}
+	}
+
+	// otherwise assume a constant bitrate
+	info.Bitrate = bitrate
+	if info.Duration == 0 && bitrate > 0 {
+		info.Duration = time.Duration(float64(audioLength*8) / float64(bitrate) * float64(time.Second))
+	}
+	return true
+}
+
+func choose(cond bool, a, b int) int {
+	if cond {
+		return a
+	}
+	return b
+}
+
+func parseID3Frames(tag []byte, major int, info *Info) {
+	idLen, headerLen := 4, 10
+	if major == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	for pos := 0; pos+headerLen <= len(tag); {
+		id := string(tag[pos : pos+idLen])
+		if id[0] == 0 {
+			// padding
+			return
+		}
+
+		var size int
+		switch major {
+		case 2:
+			size = int(tag[pos+3])<<16 | int(tag[pos+4])<<8 | int(tag[pos+5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(tag[pos+4 : pos+8]))
+		default:
+			size = syncsafe(tag[pos+4 : pos+8])
+		}
+		pos += headerLen
+		if size <= 0 || pos+size > len(tag) {
+			return
+		}
+		data := tag[pos : pos+size]
+		pos += size
+
+		switch id {
+		case "TIT2", "TT2":
+			info.Title = id3Text(data)
+		case "TPE1", "TP1":
+			info.Artist = id3Text(data)
+		case "TLEN", "TLE":
+			if ms, err := strconv.Atoi(id3Text(data)); err == nil && ms > 0 {
+				info.Duration = time.Duration(ms) * time.Millisecond
+			}
+		case "APIC":
+			if len(data) < 2 {
+				continue
+			}
+			end := bytes.IndexByte(data[1:], 0)
+			if end < 0 {
+				continue
+			}
+			mime := string(data[1 : 1+end])
+			rest := data[1+end+1:]
+			if len(rest) < 1 {
+				continue
+			}
+			info.setCover(mime, id3SkipString(data[0], rest[1:]))
+		case "PIC":
+			if len(data) < 5 {
+				continue
+			}
+			mime := "image/" + strings.ToLower(string(data[1:4]))
+			if mime == "image/jpg" {
+				mime = "image/jpeg"
+			}
+			info.setCover(mime, id3SkipString(data[0], data[5:]))
+		}
+	}
+}
+
+func id3SkipString(encoding byte, data []byte) []byte {
+	// skip a terminated description string in the given encoding
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return data[i+2:]
+			}
+		}
+		return nil
+	}
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		return nil
+	}
+	return data[end+1:]
+}
+
+func id3Text(data []byte) string {
+	if len(data) < 1 {
+		return ""
+	}
+	encoding, text := data[0], data[1:]
+	var s string
+	switch encoding {
+	case 0:
+		// iso-8859-1 maps directly onto the first 256 code points
+		runes := make([]rune, len(text))
+		for i, b := range text {
+			runes[i] = rune(b)
+		}
+		s = string(runes)
+	case 1, 2:
+		s = decodeUTF16(text, encoding == 2)
+	default:
+		s = string(text)
+	}
+	// multiple values are separated by NUL
+	if i := strings.IndexRune(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xff && b[1] == 0xfe:
+			bigEndian, b = false, b[2:]
+		case b[0] == 0xfe && b[1] == 0xff:
+			bigEndian, b = true, b[2:]
+		}
+	}
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		if bigEndian {
+			units = append(units, binary.BigEndian.Uint16(b[i:]))
+		} else {
+			units = append(units, binary.LittleEndian.Uint16(b[i:]))
+		}
+	}
+	return string(utf16.Decode(units))
+}
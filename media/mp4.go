@@ -0,0 +1,189 @@
+package media
+
+// ISO base media file format (mp4, m4a, mov)
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// limit the size of any single box payload read into memory
+const maxBoxLength = 16 * 1024 * 1024
+
+type mp4Parser struct {
+	r         io.ReadSeeker
+	info      *Info
+	timescale uint32
+	handler   string
+}
+
+func parseMP4(r io.ReadSeeker, size int64, info *Info) error {
+	info.Format = "mp4"
+	p := &mp4Parser{r: r, info: info}
+	return p.walk(0, size, 0)
+}
+
+func (p *mp4Parser) walk(start, end int64, depth int) error {
+	if depth > 16 {
+		return errors.New("mp4 boxes nested too deeply")
+	}
+
+	pos := start
+	for pos+8 <= end {
+		if _, err := p.r.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(p.r, header); err != nil {
+			return err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		kind := string(header[4:8])
+		headerLen := int64(8)
+		switch size {
+		case 0:
+			// box extends to the end of the file
+			size = end - pos
+		case 1:
+			large := make([]byte, 8)
+			if _, err := io.ReadFull(p.r, large); err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(large))
+			headerLen = 16
+		}
+		if size < headerLen || pos+size > end {
+			// truncated or corrupt; keep what was found so far
+			return nil
+		}
+
+		body := pos + headerLen
+		switch kind {
+		case "ftyp":
+			data, err := p.read(body, 4)
+			if err == nil && string(data) == "qt  " {
+				p.info.Format = "mov"
+			}
+		case "moov", "trak", "mdia", "minf", "stbl", "udta", "ilst":
+			if err := p.walk(body, pos+size, depth+1); err != nil {
+				return err
+			}
+		case "meta":
+			// iso meta is a full box but quicktime meta is not
+			data, err := p.read(body, 4)
+			if err == nil && binary.BigEndian.Uint32(data) == 0 {
+				body += 4
+			}
+			if err := p.walk(body, pos+size, depth+1); err != nil {
+				return err
+			}
+		case "mvhd", "tkhd", "hdlr", "stsd", "\xa9nam", "\xa9ART", "aART", "covr":
+			data, err := p.read(body, pos+size-body)
+			if err != nil {
+				return err
+			}
+			p.leaf(kind, data)
+		}
+
+		pos += size
+	}
+	return nil
+}
+
+func (p *mp4Parser) read(pos, length int64) ([]byte, error) {
+	if length > maxBoxLength {
+		return nil, errors.New("mp4 box too large")
+	}
+	if _, err := p.r.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(p.r, data)
+	return data, err
+}
+
+func (p *mp4Parser) leaf(kind string, data []byte) {
+	switch kind {
+	case "mvhd":
+		if len(data) < 20 {
+			return
+		}
+		var duration uint64
+		if data[0] == 1 {
+			if len(data) < 32 {
+				return
+			}
+			p.timescale = binary.BigEndian.Uint32(data[20:24])
+			duration = binary.BigEndian.Uint64(data[24:32])
+		} else {
+			p.timescale = binary.BigEndian.Uint32(data[12:16])
+			duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+		}
+		if p.timescale > 0 {
+			p.info.Duration = time.Duration(float64(duration) / float64(p.timescale) * float64(time.Second))
+		}
+	case "tkhd":
+		// width and height are 16.16 fixed point values at the end of the box
+		if len(data) < 84 {
+			return
+		}
+		width := int(binary.BigEndian.Uint32(data[len(data)-8:]) >> 16)
+		height := int(binary.BigEndian.Uint32(data[len(data)-4:]) >> 16)
+		if width > p.info.Width {
+			p.info.Width = width
+			p.info.Height = height
+		}
+	case "hdlr":
+		if len(data) >= 12 {
+			p.handler = string(data[8:12])
+		}
+	case "stsd":
+		// the first sample entry names the codec
+		if len(data) >= 16 && (p.handler == "vide" || p.handler == "soun") {
+			p.info.addCodec(string(data[12:16]))
+			if p.handler == "soun" && len(data) >= 48 && p.info.SampleRate == 0 {
+				p.info.Channels = int(binary.BigEndian.Uint16(data[32:34]))
+				p.info.SampleRate = int(binary.BigEndian.Uint32(data[40:44]) >> 16)
+			}
+		}
+	case "\xa9nam", "\xa9ART", "aART", "covr":
+		value, dataType := mp4Data(data)
+		if value == nil {
+			return
+		}
+		switch kind {
+		case "\xa9nam":
+			p.info.Title = string(value)
+		case "\xa9ART":
+			p.info.Artist = string(value)
+		case "aART":
+			if p.info.Artist == "" {
+				p.info.Artist = string(value)
+			}
+		case "covr":
+			mime := ""
+			switch dataType {
+			case 13:
+				mime = "image/jpeg"
+			case 14:
+				mime = "image/png"
+			}
+			p.info.setCover(mime, value)
+		}
+	}
+}
+
+func mp4Data(data []byte) ([]byte, uint32) {
+	// itunes metadata items wrap their value in a data box
+	if len(data) < 16 || string(data[4:8]) != "data" {
+		return nil, 0
+	}
+	size := int(binary.BigEndian.Uint32(data[0:4]))
+	if size < 16 || size > len(data) {
+		size = len(data)
+	}
+	return data[16:size], binary.BigEndian.Uint32(data[8:12]) & 0xffffff
+}
@@ -0,0 +1,142 @@
+package media
+
+// ogg streams carrying vorbis, opus, flac or theora
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// stop collecting header packets after this many pages
+const maxOggPages = 64
+
+func parseOgg(r io.ReadSeeker, size int64, info *Info) error {
+	info.Format = "ogg"
+
+	// collect the identification and comment packets of the first stream
+	packets := [][]byte{}
+	packet := []byte{}
+	var serial uint32
+	for page := 0; page < maxOggPages && len(packets) < 2; page++ {
+		header := make([]byte, 27)
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+		if string(header[0:4]) != "OggS" {
+			return errors.New("invalid ogg page")
+		}
+		pageSerial := binary.LittleEndian.Uint32(header[14:18])
+		if page == 0 {
+			serial = pageSerial
+		}
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(r, segments); err != nil {
+			return err
+		}
+		total := 0
+		for _, s := range segments {
+			total += int(s)
+		}
+		body := make([]byte, total)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return err
+		}
+		if pageSerial != serial {
+			continue
+		}
+
+		pos := 0
+		for _, s := range segments {
+			packet = append(packet, body[pos:pos+int(s)]...)
+			pos += int(s)
+			if s < 255 {
+				packets = append(packets, packet)
+				packet = []byte{}
+			}
+		}
+	}
+	if len(packets) == 0 {
+		return errors.New("no ogg packets found")
+	}
+
+	// identify the codec and find the granule rate
+	preskip := uint64(0)
+	rate := 0
+	id := packets[0]
+	switch {
+	case bytes.HasPrefix(id, []byte("\x01vorbis")) && len(id) >= 16:
+		info.addCodec("vorbis")
+		info.Channels = int(id[11])
+		info.SampleRate = int(binary.LittleEndian.Uint32(id[12:16]))
+		rate = info.SampleRate
+		if len(id) >= 24 {
+			// nominal bitrate; zero or negative when unset
+			if bitrate := int(int32(binary.LittleEndian.Uint32(id[20:24]))); bitrate > 0 {
+				info.Bitrate = bitrate
+			}
+		}
+	case bytes.HasPrefix(id, []byte("OpusHead")) && len(id) >= 16:
+		info.addCodec("opus")
+		info.Channels = int(id[9])
+		preskip = uint64(binary.LittleEndian.Uint16(id[10:12]))
+		info.SampleRate = int(binary.LittleEndian.Uint32(id[12:16]))
+		rate = 48000
+	case bytes.HasPrefix(id, []byte("\x7fFLAC")) && len(id) >= 17+34:
+		info.addCodec("flac")
+		parseStreamInfo(id[17:], info)
+		rate = info.SampleRate
+	case bytes.HasPrefix(id, []byte("\x80theora")) && len(id) >= 22:
+		info.addCodec("theora")
+		info.Width = int(id[14])<<16 | int(id[15])<<8 | int(id[16])
+		info.Height = int(id[17])<<16 | int(id[18])<<8 | int(id[19])
+	}
+
+	// read the tags
+	if len(packets) > 1 {
+		comment := packets[1]
+		switch {
+		case bytes.HasPrefix(comment, []byte("\x03vorbis")):
+			parseVorbisComment(comment[7:], info)
+		case bytes.HasPrefix(comment, []byte("OpusTags")):
+			parseVorbisComment(comment[8:], info)
+		case len(comment) > 4 && comment[0]&0x7f == 4:
+			// flac-in-ogg wraps each metadata block in its own packet
+			parseVorbisComment(comment[4:], info)
+		}
+	}
+
+	// the granule position of the last page gives the duration
+	if rate > 0 {
+		if granule, ok := lastGranule(r, size, serial); ok && granule > preskip {
+			info.Duration = time.Duration(float64(granule-preskip) / float64(rate) * float64(time.Second))
+		}
+	}
+
+	return nil
+}
+
+func lastGranule(r io.ReadSeeker, size int64, serial uint32) (uint64, bool) {
+	start := size - 64*1024
+	if start < 0 {
+		start = 0
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return 0, false
+	}
+	tail, err := io.ReadAll(r)
+	if err != nil {
+		return 0, false
+	}
+	for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+		if i+18 <= len(tail) && binary.LittleEndian.Uint32(tail[i+14:i+18]) == serial {
+			granule := binary.LittleEndian.Uint64(tail[i+6 : i+14])
+			if granule != ^uint64(0) {
+				return granule, true
+			}
+		}
+	}
+	return 0, false
+}
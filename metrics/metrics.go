@@ -0,0 +1,205 @@
+package metrics
+
+// counters, gauges and histograms exposed in the prometheus text format
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// default histogram buckets in seconds, from a quick mime check to a slow conversion
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	registry []collector
+	lock     sync.Mutex
+)
+
+func register(c collector) {
+	lock.Lock()
+	defer lock.Unlock()
+	registry = append(registry, c)
+}
+
+func escape(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+func formatLabels(names, values []string, extra ...string) string {
+	pairs := []string{}
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escape(value)))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escape(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+type series struct {
+	values []string
+	value  float64
+}
+
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+	series map[string]*series
+	mu     sync.Mutex
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, series: map[string]*series{}}
+	register(c)
+	return c
+}
+
+func (c *Counter) Add(v float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := strings.Join(values, "\x00")
+	s, ok := c.series[key]
+	if !ok {
+		s = &series{values: values}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 && len(c.series) == 0 {
+		// unlabelled counters start at zero
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.values), formatValue(s.value))
+	}
+}
+
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name, help, fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.fn()))
+}
+
+type observations struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*observations
+	mu      sync.Mutex
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*observations{}}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(values, "\x00")
+	o, ok := h.series[key]
+	if !ok {
+		o = &observations{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = o
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			o.counts[i]++
+			break
+		}
+	}
+	o.count++
+	o.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		o := h.series[key]
+		cumulative := uint64(0)
+		for i, bound := range h.buckets {
+			cumulative += o.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, o.values, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, o.values, "le", "+Inf"), o.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, o.values), formatValue(o.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, o.values), o.count)
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	lock.Lock()
+	collectors := append([]collector{}, registry...)
+	lock.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
@@ -57,16 +57,19 @@ func pidIsRunning(pid int) bool {
 	return true
 }
 
-func IsRunning() error {
-	// read pidfile
+func IsRunning() bool {
+	// read pidfile; a missing or stale pid file means the process is stopped
+	pid, _ := pidfileContents()
+	return pid != 0 && pidIsRunning(pid)
+}
+
+func Pid() int {
+	// return the running process id or zero
 	pid, _ := pidfileContents()
 	if pid != 0 && pidIsRunning(pid) {
-		// the process is still running
-		return errors.New("process is already running")
+		return pid
 	}
-
-	// no such process or stale pid file
-	return nil
+	return 0
 }
 
 func Lock() error {
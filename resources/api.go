@@ -0,0 +1,179 @@
+package resources
+
+import (
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ccammack/cannon/cache"
+	"github.com/ccammack/cannon/config"
+	"github.com/ccammack/cannon/connections"
+	"github.com/ccammack/cannon/util"
+)
+
+var statusNames = map[cache.Status]string{
+	cache.StatusNotFound: "closing",
+	cache.StatusPending:  "pending",
+	cache.StatusReady:    "ready",
+}
+
+func describe(hash string, status cache.Status, res *Resource) map[string]interface{} {
+	// the file is set when the resource is created; the rest only once it is ready
+	info := map[string]interface{}{
+		"hash":   hash,
+		"file":   res.file,
+		"status": statusNames[status],
+	}
+	if res.other != "" {
+		info["other"] = res.other
+	}
+	if res.remote != "" {
+		info["remote"] = res.remote
+	}
+	if status != cache.StatusReady {
+		return info
+	}
+
+	if res.rule != nil {
+		info["rule"] = res.rule.idx
+	}
+	if res.refused != "" {
+		info["refused"] = res.refused
+	}
+	if res.command != "" {
+		info["command"] = res.command
+		info["exit"] = res.exit
+	}
+	info["opened"] = res.opened
+	info["elapsed"] = res.elapsed.Milliseconds()
+	info["progress"] = res.progress
+	if res.pages > 0 {
+		info["pages"] = res.pages
+	}
+	return info
+}
+
+func apiCurrent(channel string) map[string]interface{} {
+	hash := currentHash(channel)
+	status, result := resourceCache.Get(hash)
+	body := map[string]interface{}{
+		"channel": channel,
+		"hash":    hash,
+		"ready":   status == cache.StatusReady,
+	}
+	if status == cache.StatusReady {
+		res := result.(*Resource)
+		body["file"] = res.file
+		if res.other != "" {
+			body["other"] = res.other
+		}
+		if res.remote != "" {
+			body["remote"] = res.remote
+		}
+		body["pages"] = res.pages
+	}
+	return body
+}
+
+func apiResources() map[string]interface{} {
+	resources := []map[string]interface{}{}
+	resourceCache.Each(func(hash string, status cache.Status, payload cache.Payload) {
+		resources = append(resources, describe(hash, status, payload.(*Resource)))
+	})
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i]["file"].(string) < resources[j]["file"].(string)
+	})
+	return map[string]interface{}{"resources": resources}
+}
+
+func apiChannels() map[string]interface{} {
+	// list the channels that have displayed a file and the viewers watching each
+	watching := map[string]int{}
+	for _, viewer := range connections.Viewers() {
+		watching[viewer["channel"].(string)]++
+	}
+	list := []map[string]interface{}{}
+	for _, name := range channelNames() {
+		list = append(list, map[string]interface{}{
+			"channel": name,
+			"hash":    currentHash(name),
+			"viewers": watching[name],
+		})
+	}
+	return map[string]interface{}{"channels": list}
+}
+
+func HandleApi(w http.ResponseWriter, r *http.Request) {
+	// read-only status endpoints: /api/current, /api/resources, /api/history, /api/channels, /api/viewers, /api/config
+	// current and history describe the channel named by ?channel=, or the default channel
+	if r.Method != http.MethodGet {
+		util.RespondJsonStatus(w, http.StatusMethodNotAllowed, map[string]interface{}{
+			"status":  "error",
+			"message": "use GET",
+		})
+		return
+	}
+
+	channel := r.URL.Query().Get("channel")
+	var body map[string]interface{}
+	switch strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/"), "/") {
+	case "current":
+		body = apiCurrent(channel)
+	case "resources":
+		body = apiResources()
+	case "history":
+		body = map[string]interface{}{"channel": channel, "history": channelHistory(channel)}
+	case "channels":
+		body = apiChannels()
+	case "viewers":
+		body = map[string]interface{}{"viewers": connections.Viewers()}
+	case "config":
+		body = config.Effective()
+	default:
+		util.RespondJsonStatus(w, http.StatusNotFound, map[string]interface{}{
+			"status":  "error",
+			"message": "unknown endpoint: " + r.URL.Path,
+		})
+		return
+	}
+	util.RespondJson(w, body)
+}
+
+func tempDirSize() int64 {
+	size := int64(0)
+	filepath.WalkDir(tempDir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+func Stats() map[string]interface{} {
+	// summarize the cache for cannond status
+	resources := []map[string]interface{}{}
+	counts := map[string]int{}
+	resourceCache.Each(func(hash string, status cache.Status, payload cache.Payload) {
+		res := payload.(*Resource)
+		resources = append(resources, map[string]interface{}{
+			"hash":   hash,
+			"file":   res.file,
+			"status": statusNames[status],
+		})
+		counts[statusNames[status]]++
+	})
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i]["file"].(string) < resources[j]["file"].(string)
+	})
+	return map[string]interface{}{
+		"resources":    resources,
+		"counts":       counts,
+		"tempDir":      tempDir,
+		"tempDirBytes": tempDirSize(),
+	}
+}
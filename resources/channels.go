@@ -0,0 +1,77 @@
+package resources
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// number of displayed files kept for /api/history in each channel
+const maxHistory = 100
+
+type historyEntry struct {
+	File  string    `json:"file"`
+	Other string    `json:"other,omitempty"`
+	Hash  string    `json:"hash"`
+	Time  time.Time `json:"time"`
+}
+
+// each channel has its own selection and history; the default channel is named ""
+type channel struct {
+	hash    string
+	history []historyEntry
+}
+
+var (
+	channels     = map[string]*channel{}
+	channelsLock sync.Mutex
+)
+
+func selectHash(name, file, other, hash string) {
+	// make the file current in the channel and remember it
+	channelsLock.Lock()
+	ch, ok := channels[name]
+	if !ok {
+		ch = &channel{}
+		channels[name] = ch
+	}
+	ch.hash = hash
+	ch.history = append(ch.history, historyEntry{file, other, hash, time.Now()})
+	if len(ch.history) > maxHistory {
+		ch.history = ch.history[len(ch.history)-maxHistory:]
+	}
+	channelsLock.Unlock()
+
+	// show it to the channel's viewers
+	publish()
+}
+
+func currentHash(name string) string {
+	channelsLock.Lock()
+	defer channelsLock.Unlock()
+	if ch, ok := channels[name]; ok {
+		return ch.hash
+	}
+	return ""
+}
+
+func channelHistory(name string) []historyEntry {
+	channelsLock.Lock()
+	defer channelsLock.Unlock()
+	entries := []historyEntry{}
+	if ch, ok := channels[name]; ok {
+		entries = append(entries, ch.history...)
+	}
+	return entries
+}
+
+func channelNames() []string {
+	channelsLock.Lock()
+	defer channelsLock.Unlock()
+	names := []string{}
+	for name := range channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,126 @@
+package resources
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/ccammack/cannon/config"
+	"github.com/ccammack/cannon/diff"
+	"github.com/ccammack/cannon/readseeker"
+	"github.com/ccammack/cannon/util"
+)
+
+// only compare the first part of very large text files
+const maxDiffLength = 1024 * 1024
+
+const imageComparisonTemplate = `
+<style>
+	.compare-controls { font-family: sans-serif; font-size: small; margin-bottom: 0.5em; }
+	.compare-stage { position: relative; display: inline-block; }
+	.compare-stage img { display: block; max-width: 100%; }
+	.compare-stage img.compare-top { position: absolute; top: 0; left: 0; width: 100%; height: 100%; object-fit: contain; }
+</style>
+<div class="compare">
+	<div class="compare-controls">
+		<label><input type="radio" name="compare-mode" value="swipe" checked> swipe</label>
+		<label><input type="radio" name="compare-mode" value="onion"> onion skin</label>
+		<input type="range" id="compare-slider" min="0" max="100" value="50">
+		<span>{{.a}} &#8596; {{.b}}</span>
+	</div>
+	<div class="compare-stage">
+		<img src="{{.urlA}}">
+		<img class="compare-top" src="{{.urlB}}">
+	</div>
+</div>
+<script>
+	(function() {
+		const slider = document.getElementById("compare-slider")
+		const top = document.querySelector(".compare-top")
+		const update = function() {
+			const mode = document.querySelector("input[name=compare-mode]:checked").value
+			if (mode == "swipe") {
+				top.style.opacity = 1
+				top.style.clipPath = "inset(0 0 0 " + slider.value + "%)"
+			} else {
+				top.style.clipPath = "none"
+				top.style.opacity = slider.value / 100
+			}
+		}
+		slider.addEventListener("input", update)
+		document.querySelectorAll("input[name=compare-mode]").forEach((e) => e.addEventListener("change", update))
+		update()
+	})()
+</script>
+`
+
+func (res *Resource) serveDiff() {
+	res.progress = append(res.progress, fmt.Sprintf("Select files: %s %s", res.file, res.other))
+
+	mimeA := strings.ToLower(GetMimeType(res.file))
+	mimeB := strings.ToLower(GetMimeType(res.other))
+	if strings.HasPrefix(mimeA, "image/") && strings.HasPrefix(mimeB, "image/") {
+		res.serveImageDiff()
+	} else {
+		res.serveTextDiff()
+	}
+
+	// serve the second file from /src/<hash>/other
+	res.otherReader = readseeker.New(res.other)
+}
+
+func (res *Resource) serveImageDiff() {
+	var b strings.Builder
+	templ := template.Must(template.New("compare").Parse(imageComparisonTemplate))
+	err := templ.Execute(&b, map[string]interface{}{
+		"a":    res.file,
+		"b":    res.other,
+		"urlA": template.URL("/src/" + res.hash),
+		"urlB": template.URL("/src/" + res.hash + "/other"),
+	})
+	if err != nil {
+		res.progress = append(res.progress, fmt.Sprintf("Error generating image comparison: %v", err))
+		res.serveRaw()
+		return
+	}
+	res.html = b.String()
+	res.progress = append(res.progress, fmt.Sprintf("Serve image comparison: %s", summarize(res.html)))
+}
+
+func readDiffLines(file string) ([]string, bool, error) {
+	_, _, binary := util.IsBinaryFile(file)
+	if binary {
+		return nil, true, nil
+	}
+	length, err := util.GetFileLength(file)
+	if err != nil {
+		return nil, false, err
+	}
+	bytes, count, err := util.GetFileBytes(file, util.Min(maxDiffLength, length))
+	if err != nil {
+		return nil, false, err
+	}
+	text := strings.ReplaceAll(string(bytes[:count]), "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n"), false, nil
+}
+
+func (res *Resource) serveTextDiff() {
+	a, binaryA, errA := readDiffLines(res.file)
+	b, binaryB, errB := readDiffLines(res.other)
+
+	switch {
+	case errA != nil:
+		res.html = fmt.Sprintf("<p>Error reading file: %s</p>", template.HTMLEscapeString(errA.Error()))
+	case errB != nil:
+		res.html = fmt.Sprintf("<p>Error reading file: %s</p>", template.HTMLEscapeString(errB.Error()))
+	case binaryA || binaryB:
+		lengthA, _ := util.GetFileLength(res.file)
+		lengthB, _ := util.GetFileLength(res.other)
+		res.html = fmt.Sprintf("<p>Cannot compare binary files: %s (%d bytes) %s (%d bytes)</p>",
+			template.HTMLEscapeString(res.file), lengthA, template.HTMLEscapeString(res.other), lengthB)
+	default:
+		_, mode := config.Diff().String()
+		res.html = diff.Html(res.file, res.other, a, b, mode == "unified")
+	}
+	res.progress = append(res.progress, fmt.Sprintf("Serve diff: %s", summarize(res.html)))
+}
@@ -0,0 +1,108 @@
+package resources
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/ccammack/cannon/cache"
+	"github.com/ccammack/cannon/connections"
+)
+
+// what a viewer was last told about its channel
+type update struct {
+	hash  string
+	ready bool
+	theme string // the {theme} of the content, empty when its rule has none
+	key   string // cache key of the content shown in the viewer's theme
+	page  int    // page the viewer is looking at
+}
+
+var (
+	publishLock sync.Mutex
+
+	// a pending request to publish; several changes in a row are sent together
+	changes = make(chan struct{}, 1)
+)
+
+func init() {
+	// publish off the request path so handlers holding mu never wait on viewers
+	go func() {
+		for range changes {
+			publishChanges()
+		}
+	}()
+}
+
+func viewerUpdate(channel string, state *connections.State) update {
+	hash := currentHash(channel)
+	res, ready := themedResource(hash, viewerTheme(state))
+	if !ready {
+		return update{hash: hash}
+	}
+	return update{hash, true, res.theme, res.hash, viewerPage(state, hash)}
+}
+
+func (u update) message() map[string]interface{} {
+	message := map[string]interface{}{
+		"action": "update",
+		"hash":   u.hash,
+		"ready":  u.ready,
+		"theme":  u.theme,
+	}
+
+	// send the converted file along so the page can swap it in without reloading
+	if status, result := resourceCache.Get(u.key); u.ready && status == cache.StatusReady {
+		res := result.(*Resource)
+		page := res.convertedPage(u.page)
+		message["title"] = res.title()
+		message["html"] = page.html
+		message["metadata"] = res.metadata
+		message["style"] = pageStyle(res)
+		message["page"] = page.page
+		message["pages"] = res.pages
+		message["reload"] = res.wantsReload()
+	}
+	return message
+}
+
+func publish() {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+func tell(state *connections.State, reply func(interface{}), u update) {
+	// send an update unless the viewer already has it
+	if last, ok := state.Get("published").(update); ok && last == u {
+		return
+	}
+	state.Set("published", u)
+	reply(u.message())
+}
+
+func publishChanges() {
+	// tell each viewer about changes it has not seen yet
+	publishLock.Lock()
+	defer publishLock.Unlock()
+	connections.Each(func(channel string, state *connections.State, reply func(interface{})) {
+		tell(state, reply, viewerUpdate(channel, state))
+	})
+}
+
+func greet(reply func(interface{}), state *connections.State, data map[string]interface{}) {
+	// bring a viewer that just connected up to date
+	channel, _ := data["channel"].(string)
+	if scheme, _ := data["scheme"].(string); scheme == "dark" || scheme == "light" {
+		state.Set("scheme", scheme)
+	}
+
+	// a page that reloads keeps its place in a multi-page file
+	query, _ := data["page"].(string)
+	if page, err := strconv.Atoi(query); err == nil && page > 1 {
+		state.Set("page", shownPage{currentHash(channel), page})
+	}
+	publishLock.Lock()
+	defer publishLock.Unlock()
+	tell(state, reply, viewerUpdate(channel, state))
+}
@@ -0,0 +1,190 @@
+package resources
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ccammack/cannon/assets"
+	"github.com/ccammack/cannon/config"
+	"github.com/ccammack/cannon/media"
+)
+
+// limit the number of frames extracted for one strip
+const maxFrames = 64
+
+const scrubTemplate = `
+<style>
+	.scrub { max-width: 100%; background-repeat: no-repeat; cursor: ew-resize; }
+	.scrub-time { font-family: sans-serif; font-size: small; }
+	.scrub-sheet { display: block; width: 100%; margin-top: 0.5em; }
+</style>
+<div class="scrub" style="width: {{.width}}px; aspect-ratio: {{.width}} / {{.height}}; background-image: url('{{.url}}'); background-size: {{.size}}% 100%; background-position: 0 0"></div>
+<div class="scrub-time">{{index .times 0}}</div>
+<img class="scrub-sheet" src="{{.url}}">
+<script>
+	(function() {
+		const times = {{.times}}
+		const scrub = document.querySelector(".scrub")
+		const label = document.querySelector(".scrub-time")
+		scrub.addEventListener("mousemove", (e) => {
+			const rect = scrub.getBoundingClientRect()
+			const i = Math.max(0, Math.min(times.length - 1, Math.floor((e.clientX - rect.left) / rect.width * times.length)))
+			scrub.style.backgroundPosition = (times.length > 1 ? i * 100 / (times.length - 1) : 0) + "% 0"
+			label.textContent = times[i]
+		})
+	})()
+</script>
+`
+
+func probeDuration(resource *Resource, rule ConversionRule) time.Duration {
+	// prefer the rule's duration: command
+	if len(rule.duration) != 0 {
+		probe := &Resource{file: resource.file, tmpOutputFile: resource.tmpOutputFile, rule: resource.rule, page: resource.page, theme: resource.theme}
+		exit := runCommand(probe, rule.duration, nil)
+		resource.progress = append(resource.progress, probe.progress...)
+		if exit == 0 {
+			seconds, err := strconv.ParseFloat(regexp.MustCompile(`\d+(\.\d+)?`).FindString(probe.stdout), 64)
+			if err == nil && seconds > 0 {
+				return time.Duration(seconds * float64(time.Second))
+			}
+		}
+		resource.progress = append(resource.progress, fmt.Sprintf("Error reading duration: %s", summarize(probe.stdout+probe.stderr)))
+	}
+
+	// fall back to the builtin container parser
+	info, err := media.Probe(resource.file)
+	if err == nil && info.Duration > 0 {
+		return info.Duration
+	}
+	return 0
+}
+
+func formatTimestamp(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+func (resource *Resource) serveFrames(rule ConversionRule) bool {
+	if !strings.Contains(rule.src, "{frame}") {
+		resource.progress = append(resource.progress, "Error extracting frames: src: requires the '{frame}' placeholder")
+		return false
+	}
+
+	// spread the frames evenly over the duration
+	count := rule.frames
+	if count > maxFrames {
+		count = maxFrames
+	}
+	duration := probeDuration(resource, rule)
+	if duration <= 0 {
+		count = 1
+	}
+	resource.progress = append(resource.progress, fmt.Sprintf("Extract frames: %d over %v", count, duration))
+
+	files := []string{}
+	times := []string{}
+	for i := 0; i < count; i++ {
+		at := time.Duration(float64(duration) * (float64(i) + 0.5) / float64(count))
+		frame := strconv.Itoa(i + 1)
+
+		// each extraction is subject to the conversion timeout
+		exit := runCommand(resource, rule.cmd, map[string]string{
+			"{time}":  fmt.Sprintf("%.3f", at.Seconds()),
+			"{frame}": frame,
+		})
+		if exit != 0 {
+			resource.progress = append(resource.progress, fmt.Sprintf("Frame %s failed with status code: %d", frame, exit))
+			continue
+		}
+
+		src := config.ReplacePlaceholder(rule.src, "{output}", resource.tmpOutputFile)
+		files = append(files, config.ReplacePlaceholder(src, "{frame}", frame))
+		times = append(times, formatTimestamp(at))
+	}
+	if len(files) == 0 {
+		conversionFailures.Inc(resource.ruleLabels()...)
+		return false
+	}
+
+	// compose the frames into a single contact sheet
+	sheet := resource.tmpOutputFile + "-sheet.jpg"
+	width, height, err := composeContactSheet(files, sheet)
+	if err != nil {
+		resource.progress = append(resource.progress, fmt.Sprintf("Error composing contact sheet: %v", err))
+		conversionFailures.Inc(resource.ruleLabels()...)
+		return false
+	}
+	resource.srcFile = sheet
+
+	var b strings.Builder
+	templ := template.Must(template.New("scrub").Parse(scrubTemplate))
+	err = templ.Execute(&b, map[string]interface{}{
+		"url":    template.URL(resource.url()),
+		"width":  width,
+		"height": height,
+		"size":   len(files) * 100,
+		"times":  times,
+	})
+	if err != nil {
+		resource.progress = append(resource.progress, fmt.Sprintf("Error generating scrub strip: %v", err))
+		return false
+	}
+
+	html := rule.html
+	if html == "" {
+		html = "{strip}"
+	}
+	html = config.ReplacePlaceholder(html, "{assets}", assets.Prefix)
+	html = config.ReplacePlaceholder(html, "{theme}", resource.theme)
+	resource.html = config.ReplacePlaceholder(html, "{strip}", b.String())
+	resource.progress = append(resource.progress, fmt.Sprintf("Serve frames: %s", summarize(resource.html)))
+	return true
+}
+
+func composeContactSheet(files []string, output string) (int, int, error) {
+	frames := []image.Image{}
+	for _, file := range files {
+		fp, err := os.Open(file)
+		if err != nil {
+			return 0, 0, err
+		}
+		img, _, err := image.Decode(fp)
+		fp.Close()
+		if err != nil {
+			return 0, 0, err
+		}
+		frames = append(frames, img)
+	}
+	if len(frames) == 0 {
+		return 0, 0, errors.New("no frames to compose")
+	}
+
+	// lay the frames out left to right using the size of the first one
+	width := frames[0].Bounds().Dx()
+	height := frames[0].Bounds().Dy()
+	sheet := image.NewRGBA(image.Rect(0, 0, width*len(frames), height))
+	for i, frame := range frames {
+		cell := image.Rect(i*width, 0, (i+1)*width, height)
+		draw.Draw(sheet, cell, frame, frame.Bounds().Min, draw.Src)
+	}
+
+	fp, err := os.Create(output)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fp.Close()
+	if err := jpeg.Encode(fp, sheet, &jpeg.Options{Quality: 85}); err != nil {
+		return 0, 0, err
+	}
+	return width, height, nil
+}
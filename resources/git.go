@@ -0,0 +1,122 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ccammack/cannon/config"
+	"github.com/ccammack/cannon/diff"
+)
+
+var gitStatusCodes = map[byte]string{
+	'M': "modified",
+	'T': "type changed",
+	'A': "added",
+	'D': "deleted",
+	'R': "renamed",
+	'C': "copied",
+	'U': "unmerged",
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	proc := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	output, err := proc.Output()
+	return string(output), err
+}
+
+func gitStatus(porcelain string) string {
+	// summarize the two column porcelain status of a single file
+	if porcelain == "" {
+		return "unmodified"
+	}
+	if strings.HasPrefix(porcelain, "??") {
+		return "untracked"
+	}
+	if strings.HasPrefix(porcelain, "!!") {
+		return "ignored"
+	}
+	status := []string{}
+	if len(porcelain) >= 2 {
+		if s, ok := gitStatusCodes[porcelain[0]]; ok {
+			status = append(status, s+" (staged)")
+		}
+		if s, ok := gitStatusCodes[porcelain[1]]; ok {
+			status = append(status, s)
+		}
+	}
+	return strings.Join(status, ", ")
+}
+
+func (res *Resource) gitEnabled() bool {
+	if res.rule != nil {
+		return res.rule.git
+	}
+	_, enabled := config.Git().Bool()
+	return enabled
+}
+
+func (res *Resource) probeGit() {
+	if !res.gitEnabled() {
+		return
+	}
+
+	// run git in the file's directory under the conversion timeout
+	_, timeout := config.Timeout().Int()
+	ctx, cancel := context.WithTimeout(conversionCtx, time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+
+	dir := filepath.Dir(res.file)
+	name := filepath.Base(res.file)
+	branch, err := git(ctx, dir, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		// not inside a repository or git is missing
+		res.progress = append(res.progress, fmt.Sprintf("Skip git context: %v", err))
+		return
+	}
+	fields := [][2]string{{"Branch", strings.TrimSpace(branch)}}
+
+	// the last commit touching the file
+	commit, err := git(ctx, dir, "log", "-1", "--format=%h%x00%an <%ae>%x00%ad%x00%s", "--date=format:%Y-%m-%d %H:%M", "--", name)
+	if err == nil {
+		if parts := strings.SplitN(strings.TrimSpace(commit), "\x00", 4); len(parts) == 4 {
+			fields = append(fields,
+				[2]string{"Commit", parts[0] + " " + parts[3]},
+				[2]string{"Author", parts[1]},
+				[2]string{"Date", parts[2]})
+		}
+	}
+
+	status, err := git(ctx, dir, "status", "--porcelain", "--ignored", "--", name)
+	if err == nil {
+		fields = append(fields, [2]string{"Status", gitStatus(strings.TrimRight(status, "\n"))})
+	}
+
+	// staged and unstaged changes since the last commit
+	patch, err := git(ctx, dir, "diff", "--no-color", "--no-ext-diff", "HEAD", "--", name)
+	if ctx.Err() == context.DeadlineExceeded {
+		res.progress = append(res.progress, "Error reading git context: git timed out")
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(`<div class="git-info"><table>`)
+	for _, field := range fields {
+		b.WriteString(`<tr><th>` + template.HTMLEscapeString(field[0]) + `</th><td>` + template.HTMLEscapeString(field[1]) + `</td></tr>`)
+	}
+	b.WriteString(`</table>`)
+	if err == nil && patch != "" {
+		if len(patch) > maxDiffLength {
+			patch = patch[:maxDiffLength]
+		}
+		b.WriteString(`<details open><summary>Uncommitted changes</summary>` + diff.Patch(patch) + `</details>`)
+	}
+	b.WriteString(`</div>`)
+
+	res.metadata += b.String()
+	res.progress = append(res.progress, fmt.Sprintf("Read git context: %v", fields))
+}
@@ -10,8 +10,10 @@ const PageTemplate = `
 		<title>
 			{{.title}}
 		</title>
-		<style type="text/css">
+		<style id="style" type="text/css">
 			{{.style}}
+		</style>
+		<style type="text/css">
 			.loading {
 				display: none;
 				position: absolute;
@@ -21,8 +23,8 @@ const PageTemplate = `
 				width: 32px;
 				height: 32px;
 				border-radius: 50%;
-				border: 4px solid #ddd;
-				border-top-color: blue;
+				border: 4px solid var(--cannon-muted, #ddd);
+				border-top-color: var(--cannon-accent, blue);
 				animation: loading 1s linear infinite;
 			}
 			@keyframes loading {
@@ -30,25 +32,200 @@ const PageTemplate = `
 					transform: rotate(360deg);
 				}
 			}
+			.media-info {
+				display: flex;
+				gap: 1em;
+				align-items: flex-start;
+				margin-bottom: 0.5em;
+				font-family: sans-serif;
+				font-size: small;
+			}
+			.media-info th {
+				text-align: left;
+				padding-right: 1em;
+			}
+			.builtin-error {
+				font-family: sans-serif;
+				font-size: small;
+			}
+			.upload-info {
+				margin-bottom: 0.5em;
+				font-family: sans-serif;
+				font-size: small;
+			}
+			.git-info {
+				margin-bottom: 0.5em;
+				font-family: sans-serif;
+				font-size: small;
+			}
+			.git-info th {
+				text-align: left;
+				padding-right: 1em;
+			}
+			.pager {
+				font-family: sans-serif;
+				margin-bottom: 0.5em;
+			}
+			.media-cover {
+				max-width: 128px;
+				max-height: 128px;
+			}
+			.message {
+				display: none;
+				position: fixed;
+				right: 1em;
+				bottom: 1em;
+				z-index: 9999;
+				padding: 0.5em 1em;
+				border-radius: 4px;
+				background: var(--cannon-text, #333);
+				color: var(--cannon-background, #fff);
+				font-family: sans-serif;
+				font-size: small;
+			}
 		</style>
 		<script>
-			const hash = {{.hash}}
+			const keys = {{.keys}}
+			let hash = {{.hash}}
+			let theme = {{.theme}}
+			let pages = {{.pages}}
+			let page = {{.page}}
+			let fresh = {{.reload}}
+			let stale = false
 			let timerId = null
+			let messageId = null
+			let requestPage = function(n) {}
+			const setHtml = function(html) {
+				// scripts added with innerHTML do not run, so replace each one with a copy that will
+				const container = document.getElementById("container")
+				container.innerHTML = html
+				container.querySelectorAll("script").forEach((old) => {
+					const script = document.createElement("script")
+					Array.from(old.attributes).forEach((attr) => { script.setAttribute(attr.name, attr.value) })
+					script.textContent = old.textContent
+					old.replaceWith(script)
+				})
+			}
+			const setPageParam = function(n) {
+				// keep the page in the address so a reload shows the same one
+				const url = new URL(document.location.href)
+				if (n > 1) {
+					url.searchParams.set("page", n)
+				} else {
+					url.searchParams.delete("page")
+				}
+				history.replaceState(null, "", url)
+			}
+			const setPager = function() {
+				document.querySelector(".pager").style.display = pages > 1 ? "" : "none"
+				document.getElementById("pagenum").textContent = page + " / " + pages
+			}
+			const swappable = function() {
+				// custom templates without these elements reload instead
+				return ["style", "metadata", "container", "pagenum"].every((id) => document.getElementById(id)) &&
+					document.querySelector(".pager") && document.querySelector(".loading")
+			}
+			const swap = function(data) {
+				// show the converted file in place of the current one
+				clearTimeout(timerId)
+				timerId = null
+				if (data.hash != hash) {
+					window.scrollTo(0, 0)
+				}
+				hash = data.hash
+				theme = data.theme
+				page = data.page
+				pages = data.pages
+				fresh = data.reload
+				stale = false
+				document.title = data.title
+				document.getElementById("style").textContent = data.style
+				document.getElementById("metadata").innerHTML = data.metadata
+				setHtml(data.html)
+				setPager()
+				setPageParam(page)
+				document.querySelector('.loading').style.display = 'none';
+			}
+			const setZoom = function(zoom) {
+				document.getElementById("container").style.zoom = zoom
+				sessionStorage.setItem("cannon-zoom", zoom)
+			}
+			const showMessage = function(text) {
+				const message = document.querySelector('.message')
+				message.textContent = text
+				message.style.display = 'block'
+				clearTimeout(messageId)
+				messageId = setTimeout(() => { message.style.display = 'none' }, 2000)
+			}
 			window.onload = function(e) {
 			    // display hash
 				// document.body.prepend(Object.assign(document.createElement('div'), { textContent: hash }));
 
+				// remember the color scheme so {theme} rules are rendered to match this browser
+				const dark = window.matchMedia("(prefers-color-scheme: dark)")
+				const scheme = function() { return dark.matches ? "dark" : "light" }
+				document.cookie = "cannon-scheme=" + scheme() + "; path=/; SameSite=Strict"
+
 				// open websocket
-				const socket = new WebSocket((document.location.href).replace(/^https?:/, "ws:"))
+				const url = new URL(document.location.href)
+				url.protocol = url.protocol.replace(/^http/, "ws")
+				url.searchParams.set("scheme", scheme())
+				const socket = new WebSocket(url)
 				const sendMessage = function(obj) { socket.send(JSON.stringify(obj)) }
-				socket.onopen = function(e) {}
+				requestPage = function(n) {
+					if (n >= 1 && n <= pages && n != page) {
+						document.querySelector('.loading').style.display = 'block';
+						sendMessage({ "action": "page", "hash": hash, "page": n })
+					}
+				}
+
+				// report changes to the color scheme
+				dark.addEventListener("change", function() {
+					document.cookie = "cannon-scheme=" + scheme() + "; path=/; SameSite=Strict"
+					sendMessage({ "action": "theme", "scheme": scheme() })
+				})
+
+				// send the bound keys to the server, which runs their commands for this page
+				document.addEventListener("keydown", function(e) {
+					if (e.ctrlKey || e.altKey || e.metaKey || e.target.closest("input, textarea, select, [contenteditable]")) {
+						return
+					}
+					if (keys.includes(e.key)) {
+						e.preventDefault()
+						sendMessage({ "action": "key", "key": e.key, "hash": hash, "page": page })
+					}
+				})
+
+				// keep the zoom level across reloads
+				const zoom = parseFloat(sessionStorage.getItem("cannon-zoom"))
+				if (zoom) {
+					setZoom(zoom)
+				}
+				socket.onopen = function(e) {
+					if (zoom) {
+						sendMessage({ "action": "zoom", "zoom": zoom })
+					}
+				}
 				socket.onerror = function(error) {}
 				socket.onclose = function(event) {}
 				socket.onmessage = function(event) {
 					const data = JSON.parse(event.data)
 					switch (data.action) {
 						case "update":
-							if (data.hash != hash) {
+							if (data.hash == hash && !data.ready) {
+								// the file is being converted again
+								stale = true
+							}
+							if (data.ready && (data.hash != hash || data.theme != theme || stale)) {
+								if (fresh || data.reload || !swappable()) {
+									// rules with document-wide scripts get a page of their own
+									sendMessage({ "action": "close" })
+									setPageParam(data.page)
+									requestAnimationFrame(() => { location.reload() })
+								} else {
+									swap(data)
+								}
+							} else if (!data.ready && (data.hash != hash || stale)) {
 								// the item to display has changed
 								if (!timerId) {
 									// show the spinner after a short timeout
@@ -56,14 +233,38 @@ const PageTemplate = `
 										document.querySelector('.loading').style.display = 'block';
 									}, 100)
 								}
-
-								// reload when ready
-								if (data.ready) {
-									sendMessage({ "action": "close" })
-									requestAnimationFrame(() => { location.reload() })
-								}
 							}
 							break
+						case "page":
+							if (data.hash == hash && !swappable()) {
+								setPageParam(data.page)
+								location.reload()
+							} else if (data.hash == hash) {
+								// display the requested page
+								page = data.page
+								setHtml(data.html)
+								setPager()
+								setPageParam(page)
+								document.querySelector('.loading').style.display = 'none';
+							}
+							break
+						case "html":
+							if (data.hash == hash) {
+								// switch between the converted and raw views
+								setHtml(data.html)
+							}
+							break
+						case "zoom":
+							setZoom(data.zoom)
+							break
+						case "copy":
+							navigator.clipboard.writeText(data.text).then(
+								() => { showMessage("Copied " + data.text) },
+								() => { showMessage("Error copying " + data.text) })
+							break
+						case "message":
+							showMessage(data.text)
+							break
 						case "shutdown":
 							document.title = "Cannon preview";
 							const container = document.getElementById("container");
@@ -78,8 +279,15 @@ const PageTemplate = `
 		</script>
 	</head>
 	<body>
+		<div class="pager"{{if lt .pages 2}} style="display: none"{{end}}>
+			<button onclick="requestPage(page - 1)">&#9664;</button>
+			<span id="pagenum">{{.page}} / {{.pages}}</span>
+			<button onclick="requestPage(page + 1)">&#9654;</button>
+		</div>
+		<div id="metadata">{{.metadata}}</div>
 		<div id="container">{{.html}}</div>
 		<div class="loading"></div>
+		<div class="message"></div>
 	</body>
 </html>
 `
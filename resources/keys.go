@@ -0,0 +1,248 @@
+package resources
+
+// keys pressed in the preview page, bound to commands by the keys: setting
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"sort"
+
+	"github.com/ccammack/cannon/cache"
+	"github.com/ccammack/cannon/config"
+	"github.com/ccammack/cannon/connections"
+	"github.com/ccammack/cannon/util"
+)
+
+const (
+	zoomStep = 1.25
+	minZoom  = 0.25
+	maxZoom  = 8.0
+)
+
+type command func(reply func(interface{}), state *connections.State, res *Resource, data map[string]interface{})
+
+var commands = map[string]command{
+	"raw":        toggleRaw,
+	"next-rule":  nextRule,
+	"reconvert":  reconvert,
+	"next-page":  nextPage,
+	"prev-page":  prevPage,
+	"zoom-in":    zoomIn,
+	"zoom-out":   zoomOut,
+	"zoom-reset": zoomReset,
+	"copy-path":  copyPath,
+	"open":       openFile,
+}
+
+func boundKeys() []string {
+	// the page only sends the keys that do something
+	_, keys := config.Keys().StringMap()
+	bound := []string{}
+	for key := range keys {
+		bound = append(bound, key)
+	}
+	sort.Strings(bound)
+	return bound
+}
+
+func message(reply func(interface{}), format string, args ...interface{}) {
+	reply(map[string]interface{}{
+		"action": "message",
+		"text":   fmt.Sprintf(format, args...),
+	})
+}
+
+func handleKey(reply func(interface{}), state *connections.State, data map[string]interface{}) {
+	key, _ := data["key"].(string)
+	hash, _ := data["hash"].(string)
+
+	keysk, keys := config.Keys().StringMap()
+	name, ok := keys[key]
+	if !ok {
+		return
+	}
+	run, ok := commands[name]
+	if !ok {
+		log.Printf("Error running %s[%s]: unknown command: %s", keysk, key, name)
+		message(reply, "Unknown command: %s", name)
+		return
+	}
+
+	// zoom works on the waiting page too; the other commands need a file
+	var res *Resource
+	if status, result := resourceCache.Get(hash); status == cache.StatusReady {
+		res = result.(*Resource)
+	}
+	if res == nil && name != "zoom-in" && name != "zoom-out" && name != "zoom-reset" {
+		return
+	}
+	run(reply, state, res, data)
+}
+
+func handleZoom(reply func(interface{}), state *connections.State, data map[string]interface{}) {
+	// the page restores its zoom after reloading
+	if level, ok := data["zoom"].(float64); ok {
+		state.Set("zoom", math.Max(minZoom, math.Min(maxZoom, level)))
+	}
+}
+
+func replace(res *Resource, skip int) {
+	// convert the file again; the pages showing it update when it is ready
+	mu.Lock()
+	defer mu.Unlock()
+	if _, current := resourceCache.Get(res.hash); current != res {
+		// another command already replaced it
+		return
+	}
+	renewed := res.renew()
+	renewed.skip = skip
+	evict(res.hash)
+	resourceCache.Put(res.hash, renewed)
+}
+
+func toggleRaw(reply func(interface{}), state *connections.State, res *Resource, data map[string]interface{}) {
+	if res.refused != "" {
+		message(reply, "Not allowed: %s", res.refused)
+		return
+	}
+
+	// each viewer switches between the converted and raw views on its own;
+	// remember the file in raw view so the next file starts out converted
+	raw := state.Update("raw", func(value interface{}) interface{} {
+		if value == res.hash {
+			return ""
+		}
+		return res.hash
+	}) == res.hash
+
+	page, _ := data["page"].(float64)
+	html := shownResource(res, state).Page(int(page)).html
+	if raw {
+		plain := &Resource{file: res.file}
+		plain.serveRaw()
+		html = plain.html
+	}
+	reply(map[string]interface{}{
+		"action": "html",
+		"hash":   res.hash,
+		"html":   html,
+	})
+}
+
+func nextRule(reply func(interface{}), state *connections.State, res *Resource, data map[string]interface{}) {
+	if res.refused != "" || res.other != "" {
+		message(reply, "No rules apply to this file")
+		return
+	}
+	_, rules := matchConversionRules(&Resource{file: res.file})
+	if len(rules) < 2 {
+		message(reply, "No other rules match this file")
+		return
+	}
+	skip := (res.skip + 1) % len(rules)
+	message(reply, "Apply rule[%d]", rules[skip].idx)
+	replace(res, skip)
+}
+
+func reconvert(reply func(interface{}), state *connections.State, res *Resource, data map[string]interface{}) {
+	replace(res, res.skip)
+}
+
+func nextPage(reply func(interface{}), state *connections.State, res *Resource, data map[string]interface{}) {
+	turnPage(reply, state, shownResource(res, state), data, 1)
+}
+
+func prevPage(reply func(interface{}), state *connections.State, res *Resource, data map[string]interface{}) {
+	turnPage(reply, state, shownResource(res, state), data, -1)
+}
+
+func shownResource(res *Resource, state *connections.State) *Resource {
+	// the copy of the resource converted in the viewer's theme, if it has one
+	if themed, ok := themedResource(res.hash, viewerTheme(state)); ok {
+		return themed
+	}
+	return res
+}
+
+func turnPage(reply func(interface{}), state *connections.State, res *Resource, data map[string]interface{}, step int) {
+	hash, _ := data["hash"].(string)
+	page, _ := data["page"].(float64)
+	if res.pages < 2 {
+		return
+	}
+	extra := res.Page(int(page) + step)
+	state.Set("page", shownPage{hash, extra.page})
+	reply(map[string]interface{}{
+		"action": "page",
+		"hash":   hash,
+		"page":   extra.page,
+		"pages":  res.pages,
+		"html":   extra.html,
+	})
+}
+
+func zoomIn(reply func(interface{}), state *connections.State, res *Resource, data map[string]interface{}) {
+	zoom(reply, state, zoomStep)
+}
+
+func zoomOut(reply func(interface{}), state *connections.State, res *Resource, data map[string]interface{}) {
+	zoom(reply, state, 1/zoomStep)
+}
+
+func zoomReset(reply func(interface{}), state *connections.State, res *Resource, data map[string]interface{}) {
+	zoom(reply, state, 0)
+}
+
+func zoom(reply func(interface{}), state *connections.State, factor float64) {
+	// a factor of zero resets the zoom
+	level := state.Update("zoom", func(value interface{}) interface{} {
+		level, ok := value.(float64)
+		if !ok || factor == 0 {
+			return 1.0
+		}
+		return math.Max(minZoom, math.Min(maxZoom, level*factor))
+	})
+	reply(map[string]interface{}{
+		"action": "zoom",
+		"zoom":   level,
+	})
+}
+
+func copyPath(reply func(interface{}), state *connections.State, res *Resource, data map[string]interface{}) {
+	path := res.file
+	if res.remote != "" {
+		path = res.remote
+	}
+	reply(map[string]interface{}{
+		"action": "copy",
+		"text":   path,
+	})
+}
+
+func openFile(reply func(interface{}), state *connections.State, res *Resource, data map[string]interface{}) {
+	openk, open := config.Open().Strings()
+	switch {
+	case len(open) == 0:
+		message(reply, "Set %s to open files", openk)
+		return
+	case res.refused != "":
+		message(reply, "Not allowed: %s", res.refused)
+		return
+	case res.remote != "":
+		message(reply, "Cannot open uploaded files")
+		return
+	}
+
+	// start the default application and reap it when it exits
+	cmd, args := util.FormatCommand(open, map[string]string{"{input}": res.file})
+	proc := exec.Command(cmd, args...)
+	if err := proc.Start(); err != nil {
+		log.Printf("Error opening file: %v", err)
+		message(reply, "Error opening file: %v", err)
+		return
+	}
+	go proc.Wait()
+	message(reply, "Opened %s", res.file)
+}
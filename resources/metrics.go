@@ -0,0 +1,47 @@
+package resources
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ccammack/cannon/metrics"
+)
+
+var (
+	conversionSeconds  = metrics.NewHistogram("cannon_conversion_duration_seconds", "Time spent converting a file for display.", metrics.DefaultBuckets, "rule", "name")
+	conversionTimeouts = metrics.NewCounter("cannon_conversion_timeouts_total", "Conversion commands and builtins stopped by the timeout.", "rule", "name")
+	conversionFailures = metrics.NewCounter("cannon_conversion_failures_total", "Conversions that failed and fell back to the raw file.", "rule", "name")
+	cacheHits          = metrics.NewCounter("cannon_cache_hits_total", "Display requests served by an existing resource.")
+	cacheMisses        = metrics.NewCounter("cannon_cache_misses_total", "Display requests that created a new resource.")
+	srcBytes           = metrics.NewCounter("cannon_src_bytes_total", "Bytes served from /src/.")
+	mimeSeconds        = metrics.NewHistogram("cannon_mime_detection_seconds", "Time spent running the mime: command.", metrics.DefaultBuckets)
+)
+
+func (res *Resource) ruleLabels() []string {
+	// label conversions by rule index and the first pattern the rule matches
+	switch {
+	case res.other != "":
+		return []string{"diff", "diff"}
+	case res.rule == nil:
+		return []string{"none", "raw"}
+	}
+	name := ""
+	if len(res.rule.Ext) != 0 {
+		name = res.rule.Ext[0]
+	} else if len(res.rule.Mime) != 0 {
+		name = res.rule.Mime[0]
+	}
+	return []string{strconv.Itoa(res.rule.idx), name}
+}
+
+// count the bytes written to the response
+type countingWriter struct {
+	http.ResponseWriter
+	count int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.count += n
+	return n, err
+}
@@ -0,0 +1,108 @@
+package resources
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/ccammack/cannon/config"
+)
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			return home + path[1:]
+		}
+	}
+	return path
+}
+
+func globPattern(glob string) *regexp.Regexp {
+	// ** crosses directories, * and ? stay within one path element
+	var b strings.Builder
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		// the default file systems there ignore case
+		b.WriteString("(?i)")
+	}
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func denied(glob, path string) bool {
+	glob = filepath.ToSlash(expandHome(glob))
+	path = filepath.ToSlash(path)
+
+	// patterns without a directory match the file name at any depth
+	if !strings.Contains(glob, "/") {
+		return globPattern(glob).MatchString(filepath.Base(path))
+	}
+	return globPattern(glob).MatchString(path)
+}
+
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+func allowed(file string) (string, error) {
+	// check the real file, not the link that points to it, and return it so the caller opens the same one
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	rootsk, roots := config.Roots().Strings()
+	if len(roots) != 0 {
+		inside := false
+		for _, root := range roots {
+			root, err := filepath.Abs(expandHome(root))
+			if err != nil {
+				continue
+			}
+			if resolved, err := filepath.EvalSymlinks(root); err == nil {
+				root = resolved
+			}
+			inside = inside || within(root, real)
+		}
+		if !inside {
+			return "", fmt.Errorf("%s is outside of %s%v", real, rootsk, roots)
+		}
+	}
+
+	return real, refused(real, path)
+}
+
+func refused(paths ...string) error {
+	// check the deny: patterns against each name the file goes by
+	denyk, deny := config.Deny().Strings()
+	for _, glob := range deny {
+		for _, path := range paths {
+			if denied(glob, path) {
+				return fmt.Errorf("%s matches %s[%s]", paths[0], denyk, glob)
+			}
+		}
+	}
+	return nil
+}
@@ -2,25 +2,51 @@ package resources
 
 import (
 	"fmt"
+	"html/template"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/ccammack/cannon/assets"
 	"github.com/ccammack/cannon/config"
+	"github.com/ccammack/cannon/media"
 	"github.com/ccammack/cannon/readseeker"
 	"github.com/ccammack/cannon/util"
 )
 
 type Resource struct {
 	file          string // {input}
+	other         string // second file when comparing two files
+	refused       string // reason the file may not be displayed
+	remote        string // host:path of a file uploaded by a client on another host
+	size          int64  // size of the remote file, which may be larger than the upload
+	handedOff     bool   // the uploaded copy belongs to the resource that renewed this one
+	theme         string // {theme} the rule was applied with, if it uses one
+	skip          int    // matching rules to skip when choosing the rule to apply
 	hash          string
 	tmpOutputFile string // {output}
 	srcFile       string // serve this file for html src attributes
 	html          string
+	metadata      string // media summary and git context displayed above the html
 	stdout        string // {stdout}
 	stderr        string // {stderr}
 	reader        *readseeker.ReadSeeker
+	otherReader   *readseeker.ReadSeeker
 	progress      []string
+	command       string                // last conversion command run
+	exit          int                   // exit code of the last command
+	opened        time.Time             // when the conversion started
+	elapsed       time.Duration         // time spent converting
+	rule          *ConversionRule       // applied rule, kept to convert more pages on demand
+	page          int                   // {page} converted by this resource
+	pages         int                   // page count reported by the rule's pages: command
+	extra         map[int]*Resource     // pages after the first, converted on demand
+	converting    map[int]chan struct{} // closed when the page being converted is done
+	mu            sync.Mutex
 }
 
 func NewResource(tempDir string, file string, hash string) *Resource {
@@ -29,10 +55,94 @@ func NewResource(tempDir string, file string, hash string) *Resource {
 		hash:          hash,
 		tmpOutputFile: createPreviewFile(tempDir),
 		srcFile:       file,
+		page:          1,
+		extra:         map[int]*Resource{},
+		converting:    map[int]chan struct{}{},
 	}
 }
 
+func NewDiffResource(tempDir string, file string, other string, hash string) *Resource {
+	res := NewResource(tempDir, file, hash)
+	res.other = other
+	return res
+}
+
+func NewRefusedResource(tempDir string, file string, hash string, reason string) *Resource {
+	res := NewResource(tempDir, file, hash)
+	res.refused = reason
+	res.srcFile = ""
+	return res
+}
+
+func (res *Resource) renew() *Resource {
+	// make a fresh copy of the resource to convert the file again
+	var renewed *Resource
+	switch {
+	case res.refused != "":
+		renewed = NewRefusedResource(tempDir, res.file, res.hash, res.refused)
+	case res.other != "":
+		renewed = NewDiffResource(tempDir, res.file, res.other, res.hash)
+	case res.remote != "":
+		// hand the uploaded copy over so closing this resource keeps it
+		renewed = NewUploadResource(tempDir, res.file, res.remote, res.size, res.hash)
+		res.mu.Lock()
+		res.handedOff = true
+		res.mu.Unlock()
+	default:
+		renewed = NewResource(tempDir, res.file, res.hash)
+	}
+	renewed.skip = res.skip
+	return renewed
+}
+
 func (res *Resource) Open() {
+	conversions.Add(1)
+	defer conversions.Done()
+
+	res.opened = time.Now()
+	defer func() {
+		res.elapsed = time.Since(res.opened)
+		if res.refused == "" {
+			conversionSeconds.Observe(res.elapsed.Seconds(), res.ruleLabels()...)
+		}
+	}()
+
+	if res.refused != "" {
+		// show why the file is not displayed instead of reading it
+		res.html = "<p>Not allowed: " + template.HTMLEscapeString(res.refused) + "</p>"
+		res.progress = append(res.progress, fmt.Sprintf("Refuse file: %s", res.refused))
+	} else if res.other != "" {
+		// compare two files instead of converting one
+		res.serveDiff()
+	} else {
+		res.serveRule()
+
+		// describe audio and video containers
+		res.probeMetadata()
+
+		// describe the file's place in a git repository
+		res.probeGit()
+
+		// name the remote file the upload came from
+		if res.remote != "" {
+			res.metadata = res.uploadInfo() + res.metadata
+		}
+	}
+
+	// give it a reader; some converted files will fail because they are still open
+	// TODO: figure out how to wait for the output file to be closed before creating the readseeker
+	res.reader = readseeker.New(res.srcFile)
+
+	// log progress
+	for _, line := range res.progress {
+		log.Println(line)
+	}
+
+	// work complete
+	// time.Sleep(5000 * time.Millisecond)
+}
+
+func (res *Resource) serveRule() {
 	// find the first matching configuration rule
 	_, rules := matchConversionRules(res)
 	if len(rules) == 0 {
@@ -40,34 +150,162 @@ func (res *Resource) Open() {
 		res.progress = append(res.progress, "No matching rules found")
 		res.serveRaw()
 	} else {
-		// apply the first matching rule
-		rule := rules[0]
+		// apply the first matching rule, or a later one after cycling through them
+		rule := rules[res.skip%len(rules)]
+		res.rule = &rule
+		if !usesTheme(rule) {
+			res.theme = ""
+		} else if res.theme == "" {
+			// themed copies for other browsers are created with their theme already set
+			res.theme = currentTheme()
+		}
 		res.progress = append(res.progress, fmt.Sprintf("Apply rule[%d]: %v", rule.idx, rule))
 
+		// count pages for rules that convert one page at a time
+		if len(rule.pages) != 0 && len(rule.cmd) != 0 {
+			res.pages = countPages(res, rule)
+		}
+
 		if !res.serveInput(rule) && !res.serveCommand(rule) && !res.serveRaw() {
 			log.Printf("Error serving resource: %v", res)
 			res.progress = append(res.progress, fmt.Sprintf("Error serving resource: %v", res))
 		}
 	}
+}
 
-	// give it a reader; some converted files will fail because they are still open
-	// TODO: figure out how to wait for the output file to be closed before creating the readseeker
-	res.reader = readseeker.New(res.srcFile)
+func (res *Resource) Page(page int) *Resource {
+	// convert the requested page on demand and keep it for later requests
+	res.mu.Lock()
+	page = util.Max(1, util.Min(page, res.pages))
+	if page == 1 {
+		res.mu.Unlock()
+		return res
+	}
+	for {
+		if extra, ok := res.extra[page]; ok {
+			res.mu.Unlock()
+			return extra
+		}
+		done, ok := res.converting[page]
+		if !ok {
+			break
+		}
 
-	// log progress
-	for _, line := range res.progress {
+		// another viewer is converting the same page, so wait for its result
+		res.mu.Unlock()
+		<-done
+		res.mu.Lock()
+	}
+	done := make(chan struct{})
+	res.converting[page] = done
+	res.mu.Unlock()
+
+	// convert without holding the lock so other pages and viewers are not blocked
+	conversions.Add(1)
+	defer conversions.Done()
+	extra := &Resource{
+		file:          res.file,
+		hash:          res.hash,
+		tmpOutputFile: res.tmpOutputFile,
+		srcFile:       res.file,
+		rule:          res.rule,
+		page:          page,
+		theme:         res.theme,
+	}
+	extra.progress = append(extra.progress, fmt.Sprintf("Convert page %d of %d: %s", page, res.pages, res.file))
+	converted := extra.serveCommand(*res.rule)
+	if converted {
+		extra.reader = readseeker.New(extra.srcFile)
+	} else {
+		extra.serveRaw()
+	}
+	for _, line := range extra.progress {
 		log.Println(line)
 	}
 
-	// work complete
-	// time.Sleep(5000 * time.Millisecond)
+	// keep failed pages out of the cache so the next request tries again
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	delete(res.converting, page)
+	close(done)
+	if converted {
+		res.extra[page] = extra
+	}
+	return extra
+}
+
+func (res *Resource) convertedPage(page int) *Resource {
+	// the page if it has been converted, otherwise the first one
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if extra, ok := res.extra[page]; ok {
+		return extra
+	}
+	return res
+}
+
+func (res *Resource) pageReader(page int) *readseeker.ReadSeeker {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if extra, ok := res.extra[page]; ok {
+		return extra.reader
+	}
+	if page == 1 {
+		return res.reader
+	}
+	return nil
+}
+
+func (res *Resource) url() string {
+	if res.page > 1 {
+		return fmt.Sprintf("/src/%s/%d", res.hash, res.page)
+	}
+	return "/src/" + res.hash
 }
 
 func (res *Resource) Close() {
-	// cancel reader
+	// cancel readers
 	if res.reader != nil {
 		res.reader.Cancel()
 	}
+	if res.otherReader != nil {
+		res.otherReader.Cancel()
+	}
+
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	for _, extra := range res.extra {
+		extra.Close()
+	}
+
+	// uploaded copies are not needed once the resource is gone
+	if res.remote != "" && !res.handedOff {
+		os.RemoveAll(filepath.Dir(res.file))
+	}
+}
+
+func (res *Resource) wantsReload() bool {
+	// rules with importmaps and other document-wide scripts need a fresh page
+	return res.rule != nil && res.rule.reload
+}
+
+func (res *Resource) title() string {
+	if res.other != "" {
+		return filepath.Base(res.file) + " \u2194 " + filepath.Base(res.other)
+	}
+	return filepath.Base(res.file)
+}
+
+func (res *Resource) probeMetadata() {
+	info, err := media.Probe(res.file)
+	if err != nil {
+		if err != media.ErrUnknownFormat {
+			res.progress = append(res.progress, fmt.Sprintf("Error reading media metadata: %v", err))
+		}
+		return
+	}
+	res.metadata = info.HTML()
+	res.progress = append(res.progress, fmt.Sprintf("Read media metadata: %s %v %v", info.Format, info.Codecs, info.Duration))
 }
 
 func summarize(line string) string {
@@ -120,7 +358,7 @@ func (resource *Resource) serveRaw() bool {
 
 func (resource *Resource) serveInput(rule ConversionRule) bool {
 	// serve the command if available
-	if len(rule.cmd) != 0 {
+	if len(rule.cmd) != 0 || rule.builtin != "" {
 		return false
 	}
 
@@ -130,7 +368,9 @@ func (resource *Resource) serveInput(rule ConversionRule) bool {
 	}
 
 	// replace placeholders
-	resource.html = strings.ReplaceAll(rule.html, "{url}", "/src/"+resource.hash)
+	resource.html = strings.ReplaceAll(rule.html, "{url}", resource.url())
+	resource.html = strings.ReplaceAll(resource.html, "{assets}", assets.Prefix)
+	resource.html = strings.ReplaceAll(resource.html, "{theme}", resource.theme)
 	resource.progress = append(resource.progress, fmt.Sprintf("Serve selected: %s", summarize(resource.html)))
 
 	return true
@@ -138,30 +378,63 @@ func (resource *Resource) serveInput(rule ConversionRule) bool {
 
 func (resource *Resource) serveCommand(rule ConversionRule) bool {
 	// serve raw if missing command
-	if len(rule.cmd) == 0 {
+	if len(rule.cmd) == 0 && rule.builtin == "" {
 		return false
 	}
 
-	// run the command and wait
-	exit := runAndWait(resource, rule)
-	if exit != 0 {
-		// serve raw on command failure
-		resource.progress = append(resource.progress, fmt.Sprintf("Command failed with status code: %d", exit))
-		return false
+	// extract a strip of frames instead of converting once
+	if rule.frames > 0 && len(rule.cmd) != 0 {
+		return resource.serveFrames(rule)
 	}
 
-	// use the *src: value provided or guess the output file by matching the wildcard "{output}*"
-	if rule.src != "" {
-		resource.srcFile = config.ReplacePlaceholder(rule.src, "{output}", resource.tmpOutputFile)
-	} else {
-		resource.srcFile = findMatchingOutputFile(resource.tmpOutputFile)
+	if len(rule.cmd) != 0 {
+		// run the command and wait
+		exit := runAndWait(resource, rule)
+		if exit != 0 {
+			// serve raw on command failure
+			resource.progress = append(resource.progress, fmt.Sprintf("Command failed with status code: %d", exit))
+			conversionFailures.Inc(resource.ruleLabels()...)
+			return false
+		}
+
+		// use the *src: value provided or guess the output file by matching the wildcard "{output}*"
+		if rule.src != "" {
+			src := config.ReplacePlaceholder(rule.src, "{output}", resource.tmpOutputFile)
+			resource.srcFile = config.ReplacePlaceholder(src, "{page}", strconv.Itoa(resource.page))
+		} else {
+			resource.srcFile = findMatchingOutputFile(resource.tmpOutputFile)
+		}
 	}
 
-	// replace html placeholders
 	html := rule.html
+	if html == "" && rule.builtin != "" {
+		html = "{builtin}"
+	}
+
+	// run the builtin converter on the command output or the input file
+	builtin := ""
+	if rule.builtin != "" {
+		var err error
+		builtin, err = runBuiltin(resource, rule)
+		if err != nil {
+			resource.progress = append(resource.progress, fmt.Sprintf("Builtin failed: %v", err))
+			conversionFailures.Inc(resource.ruleLabels()...)
+			if strings.TrimSpace(html) == "{builtin}" {
+				// serve raw when the builtin output was all there was to show
+				return false
+			}
+
+			// keep the rest of the rule's html, such as the player beside a waveform
+			builtin = "<p class=\"builtin-error\">" + template.HTMLEscapeString(fmt.Sprintf("Error running %s: %v", rule.builtin, err)) + "</p>"
+		}
+	}
+
+	// replace html placeholders
 	html = config.ReplaceEnvPlaceholders(html)
 	html = config.ReplacePlaceholder(html, "{output}", resource.tmpOutputFile)
-	html = config.ReplacePlaceholder(html, "{url}", "/src/"+resource.hash)
+	html = config.ReplacePlaceholder(html, "{url}", resource.url())
+	html = config.ReplacePlaceholder(html, "{assets}", assets.Prefix)
+	html = config.ReplacePlaceholder(html, "{theme}", resource.theme)
 	html = config.ReplacePlaceholder(html, "{stdout}", resource.stdout)
 	html = config.ReplacePlaceholder(html, "{stderr}", resource.stderr)
 
@@ -173,6 +446,9 @@ func (resource *Resource) serveCommand(rule ConversionRule) bool {
 		}
 	}
 
+	// insert the builtin output last so its contents are left untouched
+	html = config.ReplacePlaceholder(html, "{builtin}", builtin)
+
 	// save output html
 	resource.html = html
 	resource.progress = append(resource.progress, fmt.Sprintf("Serve output: %s", summarize(resource.html)))
@@ -1,13 +1,17 @@
 package resources
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -21,10 +25,15 @@ var (
 	resourceCache = cache.New()
 	mu            sync.Mutex
 	tempDir       string = ""
-	currHash      string = ""
 	// currFile      string = ""
 )
 
+// conversions in progress; cancelled and reaped at shutdown
+var (
+	conversions                      sync.WaitGroup
+	conversionCtx, cancelConversions = context.WithCancel(context.Background())
+)
+
 func deleteTempData() {
 	// clear the resource cache
 	resourceCache.Clear()
@@ -39,54 +48,141 @@ func init() {
 	tempName := "cannon"
 	tempDir = util.CreateTempDir(tempName)
 
+	// convert pages on request from the browser
+	connections.Handle("page", handlePage)
+
+	// push changes to the viewers as they happen
+	resourceCache.RegisterCallback(func(hash string) { publish() })
+	connections.Handle("connect", greet)
+
+	// follow the browser's color scheme
+	connections.Handle("theme", handleTheme)
+
+	// run the commands bound to keys pressed in the page
+	connections.Handle("key", handleKey)
+	connections.Handle("zoom", handleZoom)
+
 	// react to config file changes
 	config.RegisterCallback(func(event string) {
 		if event == "reload" {
 			deleteTempData()
 		}
+		if event == "reloaded" {
+			loadTemplate()
+			publish()
+		}
 	})
 }
 
-func Shutdown() {
+func Shutdown(ctx context.Context) {
 	// tell the client to disconnect
 	connections.Broadcast(map[string]interface{}{
 		"action": "shutdown",
 	})
+	connections.Close()
+
+	// cancel running conversions and wait for them to exit
+	cancelConversions()
+	done := make(chan struct{})
+	go func() {
+		conversions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Error stopping conversions: %v", ctx.Err())
+	}
 
-	deleteTempData()
+	// close the readers and unmap their files before removing them
+	resourceCache.Close()
+	if len(tempDir) > 0 {
+		os.RemoveAll(tempDir)
+	}
 }
 
-func prepareTemplateVars(hash string) map[string]interface{} {
-	// set default values
+func pageStyle(res *Resource) string {
+	// the theme's colors, then the global style and the applied rule's style
 	_, style := config.Style().String()
+	style = themeStyle() + style
+	if res != nil && res.rule != nil && res.rule.style != "" {
+		style += "\n" + res.rule.style
+	}
+	return style
+}
+
+func prepareTemplateVars(hash string, theme string, page int) map[string]interface{} {
+	// set default values
 	data := map[string]interface{}{
-		"style": template.CSS(style),
+		"style":  template.CSS(pageStyle(nil)),
+		"theme":  "",
+		"keys":   boundKeys(),
+		"reload": false,
 	}
 
-	status, result := resourceCache.Get(hash)
-	if status == cache.StatusReady {
+	res, ready := themedResource(hash, theme)
+	if !ready {
+		// show the original until the copy in the browser's theme is ready
+		if status, result := resourceCache.Get(hash); status == cache.StatusReady {
+			res, ready = result.(*Resource), true
+		}
+	}
+	if ready {
 		// serve the converted output file (or error text on failure)
-		res := result.(*Resource)
-		data["title"] = template.HTMLEscapeString(filepath.Base(res.file))
-		data["hash"] = template.HTML(res.hash)
-		data["html"] = template.HTML(res.html)
+		page := res.Page(page)
+		data["style"] = template.CSS(pageStyle(res))
+		data["theme"] = res.theme
+		data["reload"] = res.wantsReload()
+		data["title"] = template.HTMLEscapeString(res.title())
+		data["hash"] = template.HTML(hash)
+		data["html"] = template.HTML(page.html)
+		data["metadata"] = template.HTML(res.metadata)
+		data["progress"] = res.progress
+		data["page"] = page.page
+		data["pages"] = res.pages
 	} else {
 		// serve default values until the first resource is added
 		data["title"] = template.HTMLEscapeString("Cannon preview")
 		data["hash"] = template.HTML("")
 		data["html"] = template.HTML("<p>Waiting for file...</p>")
+		data["metadata"] = template.HTML("")
+		data["progress"] = []string{}
+		data["page"] = 0
+		data["pages"] = 0
 	}
 
 	return data
 }
 
-func BroadcastCurrent() {
-	// send the current resource to the clients
-	status, _ := resourceCache.Get(currHash)
-	connections.Broadcast(map[string]interface{}{
-		"action": "update",
-		"hash":   currHash,
-		"ready":  (status == cache.StatusReady),
+// the page a viewer is looking at, which only applies to the file it was turned in
+type shownPage struct {
+	hash string
+	page int
+}
+
+func viewerPage(state *connections.State, hash string) int {
+	if shown, ok := state.Get("page").(shownPage); ok && shown.hash == hash {
+		return shown.page
+	}
+	return 1
+}
+
+func handlePage(reply func(interface{}), state *connections.State, data map[string]interface{}) {
+	hash, _ := data["hash"].(string)
+	page, _ := data["page"].(float64)
+
+	res, ready := themedResource(hash, viewerTheme(state))
+	if !ready {
+		return
+	}
+	extra := res.Page(int(page))
+	state.Set("page", shownPage{hash, extra.page})
+	reply(map[string]interface{}{
+		"action": "page",
+		"hash":   hash,
+		"page":   extra.page,
+		"pages":  res.pages,
+		"html":   extra.html,
 	})
 }
 
@@ -100,8 +196,9 @@ func HandleRoot(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// handle normal page generation
-		templ := template.Must(template.New("page").Parse(PageTemplate))
-		vars := prepareTemplateVars(currHash)
+		templ := currentTemplate()
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		vars := prepareTemplateVars(currentHash(r.URL.Query().Get("channel")), requestTheme(r), page)
 		err := templ.Execute(w, vars)
 		if err != nil {
 			log.Printf("error generating page: %v", err)
@@ -125,16 +222,52 @@ func HandleDisplay(w http.ResponseWriter, r *http.Request) {
 
 	// TODO: consider using file.ToLower() as the key rather than hashing
 	file := params["file"]
+	other := params["other"]
 	hash := params["hash"]
+	channel := params["channel"]
 
 	if file != "" && hash != "" {
+		// enforce the roots: and deny: settings on both files and display the paths that were checked
+		real, err := allowed(file)
+		realOther := ""
+		if err == nil && other != "" {
+			realOther, err = allowed(other)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			// lf sometimes asks for a file it just deleted, which is not a policy refusal
+			log.Printf("Error displaying file: %v", err)
+			body["status"] = template.HTML("error")
+			body["message"] = template.HTML(fmt.Sprintf("Error reading file or hash: %s %s", file, hash))
+			util.RespondJsonStatus(w, http.StatusNotFound, body)
+			return
+		}
+		if err != nil {
+			log.Printf("Error displaying file: not allowed: %v", err)
+			if status, _ := resourceCache.Get(hash); status == cache.StatusNotFound {
+				resourceCache.Put(hash, NewRefusedResource(tempDir, file, hash, err.Error()))
+			}
+			selectHash(channel, file, other, hash)
+			body["status"] = template.HTML("error")
+			body["message"] = template.HTML(fmt.Sprintf("Not allowed: %v", err))
+			util.RespondJsonStatus(w, http.StatusForbidden, body)
+			return
+		}
+
 		// create a new resource
 		status, _ := resourceCache.Get(hash)
 		if status == cache.StatusNotFound {
-			resourceCache.Put(hash, NewResource(tempDir, file, hash))
+			cacheMisses.Inc()
+			if other != "" {
+				// compare two files
+				resourceCache.Put(hash, NewDiffResource(tempDir, real, realOther, hash))
+			} else {
+				resourceCache.Put(hash, NewResource(tempDir, real, hash))
+			}
+		} else {
+			cacheHits.Inc()
 		}
 
-		currHash = hash
+		selectHash(channel, file, other, hash)
 		body["status"] = template.HTML("success")
 	} else {
 		// this is reached sometimes after deleting a file with lf
@@ -161,7 +294,7 @@ func HandleClose(w http.ResponseWriter, r *http.Request) {
 
 	if hash != "" {
 		// close the resource
-		resourceCache.Evict(hash)
+		evict(hash)
 		body["status"] = template.HTML("success")
 	} else {
 		// not sure if this ever happens
@@ -173,14 +306,26 @@ func HandleClose(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleSrc(w http.ResponseWriter, r *http.Request) {
-	// extract hash to display from url
-	hash, _ := strings.CutPrefix(r.URL.Path, "/src/")
+	// extract hash to display from url: /src/<hash> or /src/<hash>/other
+	path, _ := strings.CutPrefix(r.URL.Path, "/src/")
+	hash, side, _ := strings.Cut(path, "/")
 
 	status, result := resourceCache.Get(hash)
-	res := result.(*Resource)
 	if status == cache.StatusReady {
+		res := result.(*Resource)
 		reader := res.reader
-		http.ServeContent(w, r, filepath.Base(reader.Info.Name()), reader.Info.ModTime(), reader)
+		if side == "other" {
+			reader = res.otherReader
+		} else if page, err := strconv.Atoi(side); err == nil {
+			reader = res.pageReader(page)
+		}
+		if reader == nil {
+			http.Error(w, "http.StatusNotFound", http.StatusNotFound)
+			return
+		}
+		counter := &countingWriter{ResponseWriter: w}
+		http.ServeContent(counter, r, filepath.Base(reader.Info.Name()), reader.Info.ModTime(), reader)
+		srcBytes.Add(float64(counter.count))
 	} else {
 		http.Error(w, "http.StatusServiceUnavailable", http.StatusServiceUnavailable)
 	}
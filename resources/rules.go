@@ -16,8 +16,15 @@ type ConversionRule struct {
 	matchMime bool
 	Mime      []string
 	cmd       []string
+	builtin   string
 	src       string
 	html      string
+	pages     []string
+	frames    int
+	duration  []string
+	git       bool
+	style     string
+	reload    bool
 }
 
 func matchConversionRules(res *Resource) (string, []ConversionRule) {
@@ -39,10 +46,22 @@ func matchConversionRules(res *Resource) (string, []ConversionRule) {
 
 		if matchExt || matchMime {
 			_, cmd := rule.Cmd.Strings()
+			_, builtin := rule.Builtin.String()
 			_, src := rule.Src.String()
 			_, html := rule.Html.String()
+			_, pages := rule.Pages.Strings()
+			_, frames := rule.Frames.Int()
+			_, duration := rule.Duration.Strings()
+			_, style := rule.Style.String()
+			_, reload := rule.Reload.Bool()
 
-			match := ConversionRule{idx, matchExt, exts, matchMime, mimes, cmd, src, html}
+			// the rule's git: key overrides the global setting
+			_, git := config.Git().Bool()
+			if rule.Git.V != nil {
+				_, git = rule.Git.Bool()
+			}
+
+			match := ConversionRule{idx, matchExt, exts, matchMime, mimes, cmd, builtin, src, html, pages, frames, duration, git, style, reload}
 			res.progress = append(res.progress, fmt.Sprintf("Match rule[%d]: %v", idx, match))
 			matches = append(matches, match)
 		}
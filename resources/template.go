@@ -0,0 +1,57 @@
+package resources
+
+import (
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ccammack/cannon/config"
+)
+
+var (
+	pageTemplate *template.Template
+	templateLock sync.RWMutex
+)
+
+func loadTemplate() {
+	// parse the template: file once, falling back to the built-in page
+	source := PageTemplate
+	templatek, file := config.Template().String()
+	if file != "" {
+		// relative paths are found beside the config file
+		file = expandHome(file)
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(config.Dir(), file)
+		}
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			log.Printf("Error reading %s: %v", templatek, err)
+		} else {
+			source = string(contents)
+		}
+	}
+
+	templ, err := template.New("page").Parse(source)
+	if err != nil {
+		log.Printf("Error parsing %s: %v", templatek, err)
+		templ = template.Must(template.New("page").Parse(PageTemplate))
+	}
+
+	templateLock.Lock()
+	defer templateLock.Unlock()
+	pageTemplate = templ
+}
+
+func currentTemplate() *template.Template {
+	// parse on first use so the cannon client never reads the template
+	templateLock.RLock()
+	templ := pageTemplate
+	templateLock.RUnlock()
+	if templ == nil {
+		loadTemplate()
+		return currentTemplate()
+	}
+	return templ
+}
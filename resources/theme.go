@@ -0,0 +1,165 @@
+package resources
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ccammack/cannon/cache"
+	"github.com/ccammack/cannon/config"
+	"github.com/ccammack/cannon/connections"
+)
+
+const lightTheme = `
+	color-scheme: light;
+	--cannon-background: #ffffff;
+	--cannon-text: #1f2328;
+	--cannon-muted: #dddddd;
+	--cannon-accent: #0969da;
+	--cannon-deleted: #ffecec;
+	--cannon-inserted: #eaffea;
+	--cannon-deleted-change: #f8b4b4;
+	--cannon-inserted-change: #a6f0a6;
+	--cannon-gap: #f4f4f4;
+`
+
+const darkTheme = `
+	color-scheme: dark;
+	--cannon-background: #0d1117;
+	--cannon-text: #e6edf3;
+	--cannon-muted: #30363d;
+	--cannon-accent: #58a6ff;
+	--cannon-deleted: #3d1518;
+	--cannon-inserted: #12301c;
+	--cannon-deleted-change: #7a2a30;
+	--cannon-inserted-change: #23633a;
+	--cannon-gap: #161b22;
+`
+
+// colors used by the page, raw text, spinner, messages and diffs
+const themeRules = `
+body { background: var(--cannon-background); color: var(--cannon-text); }
+xmp, pre { color: var(--cannon-text); }
+a { color: var(--cannon-accent); }
+.loading { border-color: var(--cannon-muted); border-top-color: var(--cannon-accent); }
+table.diff td.del { background: var(--cannon-deleted); }
+table.diff td.ins { background: var(--cannon-inserted); }
+table.diff td.del span.ch { background: var(--cannon-deleted-change); }
+table.diff td.ins span.ch { background: var(--cannon-inserted-change); }
+table.diff tr.gap td { background: var(--cannon-gap); }
+`
+
+// set by the page to the browser's color scheme
+const schemeCookie = "cannon-scheme"
+
+func themeSetting() string {
+	_, theme := config.Theme().String()
+	switch theme {
+	case "dark", "light", "custom":
+		return theme
+	}
+	return "auto"
+}
+
+func currentTheme() string {
+	// the theme used until a browser reports its own
+	if themeSetting() == "dark" {
+		return "dark"
+	}
+	return "light"
+}
+
+func viewerTheme(state *connections.State) string {
+	// light or dark for one browser; auto and custom follow its color scheme
+	if theme := themeSetting(); theme == "dark" || theme == "light" {
+		return theme
+	}
+	if scheme, ok := state.Get("scheme").(string); ok {
+		return scheme
+	}
+	return currentTheme()
+}
+
+func requestTheme(r *http.Request) string {
+	// the page remembers the browser's color scheme in a cookie for the first render
+	if theme := themeSetting(); theme == "dark" || theme == "light" {
+		return theme
+	}
+	if cookie, err := r.Cookie(schemeCookie); err == nil && (cookie.Value == "dark" || cookie.Value == "light") {
+		return cookie.Value
+	}
+	return currentTheme()
+}
+
+func themeStyle() string {
+	// custom themes are left entirely to the style: settings
+	switch themeSetting() {
+	case "light":
+		return ":root {" + lightTheme + "}" + themeRules
+	case "dark":
+		return ":root {" + darkTheme + "}" + themeRules
+	case "auto":
+		return ":root {" + lightTheme + "}\n@media (prefers-color-scheme: dark) { :root {" + darkTheme + "} }" + themeRules
+	}
+	return ""
+}
+
+func usesTheme(rule ConversionRule) bool {
+	return strings.Contains(strings.Join(rule.cmd, " ")+rule.html, "{theme}")
+}
+
+func themedKey(hash, theme string) string {
+	// files converted with {theme} are cached once per theme
+	return hash + "-" + theme
+}
+
+func (res *Resource) variant(theme string) *Resource {
+	// a copy of a themed resource converted for browsers in another theme;
+	// it reads the original's file, so evict it along with the original
+	v := NewResource(tempDir, res.file, themedKey(res.hash, theme))
+	v.theme = theme
+	v.skip = res.skip
+	return v
+}
+
+func themedResource(hash, theme string) (*Resource, bool) {
+	// find the copy of a resource to show in a theme, starting its conversion if needed
+	status, result := resourceCache.Get(hash)
+	if status != cache.StatusReady {
+		return nil, false
+	}
+	res := result.(*Resource)
+	if res.theme == "" || res.theme == theme {
+		return res, true
+	}
+
+	key := themedKey(hash, theme)
+	mu.Lock()
+	defer mu.Unlock()
+	status, result = resourceCache.Get(key)
+	if status == cache.StatusReady {
+		return result.(*Resource), true
+	}
+	if status == cache.StatusNotFound {
+		if _, current := resourceCache.Get(hash); current == res {
+			resourceCache.Put(key, res.variant(theme))
+		}
+	}
+	return nil, false
+}
+
+func evict(hash string) {
+	// close a resource and its themed copies
+	resourceCache.Evict(hash)
+	resourceCache.Evict(themedKey(hash, "light"))
+	resourceCache.Evict(themedKey(hash, "dark"))
+}
+
+func handleTheme(reply func(interface{}), state *connections.State, data map[string]interface{}) {
+	// each browser reports its color scheme when it connects and when it changes
+	scheme, _ := data["scheme"].(string)
+	if scheme != "dark" && scheme != "light" {
+		return
+	}
+	state.Set("scheme", scheme)
+	publish()
+}
@@ -0,0 +1,149 @@
+package resources
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ccammack/cannon/cache"
+	"github.com/ccammack/cannon/config"
+	"github.com/ccammack/cannon/util"
+)
+
+// upload at most this many bytes unless the upload: setting says otherwise
+const defaultUploadLimit = 16 << 20
+
+func UploadLimit() int64 {
+	_, limit := config.Upload().Int()
+	if limit <= 0 {
+		return defaultUploadLimit
+	}
+	return int64(limit)
+}
+
+func NewUploadResource(tempDir string, file string, remote string, size int64, hash string) *Resource {
+	res := NewResource(tempDir, file, hash)
+	res.remote = remote
+	res.size = size
+	return res
+}
+
+func (res *Resource) uploadInfo() string {
+	// say where the copy came from and whether it was cut short
+	info, err := os.Stat(res.file)
+	if err != nil || info.Size() >= res.size {
+		return fmt.Sprintf("<div class=\"upload-info\">%s</div>", template.HTMLEscapeString(res.remote))
+	}
+	return fmt.Sprintf("<div class=\"upload-info\">%s (showing the first %d of %d bytes)</div>",
+		template.HTMLEscapeString(res.remote), info.Size(), res.size)
+}
+
+func saveUpload(r *http.Request, dir string) (string, error) {
+	// write to a temp name so concurrent uploads of the same version do not share a file
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	fp, err := os.CreateTemp(dir, "upload")
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+
+	// keep the prefix that fits within the limit
+	_, err = io.Copy(fp, io.LimitReader(r.Body, UploadLimit()))
+	if err != nil {
+		os.Remove(fp.Name())
+	}
+	return fp.Name(), err
+}
+
+func HandleUpload(w http.ResponseWriter, r *http.Request) {
+	// receive a file from a cannon client on another host: /upload?host=&file=&mtime=&size=&channel=
+	if r.Method != http.MethodPost {
+		util.RespondJsonStatus(w, http.StatusMethodNotAllowed, map[string]interface{}{
+			"status":  "error",
+			"message": "use POST",
+		})
+		return
+	}
+
+	query := r.URL.Query()
+	host := query.Get("host")
+	file := query.Get("file")
+	mtime := query.Get("mtime")
+	channel := query.Get("channel")
+	size, _ := strconv.ParseInt(query.Get("size"), 10, 64)
+	if host == "" || file == "" {
+		util.RespondJsonStatus(w, http.StatusBadRequest, map[string]interface{}{
+			"status":  "error",
+			"message": fmt.Sprintf("Error reading host or file: %s %s", host, file),
+		})
+		return
+	}
+	remote := host + ":" + file
+	hash := util.HashRemote(host, file, mtime)
+
+	// enforce the deny: setting on the remote path; roots: only applies to local files
+	if err := refused(file); err != nil {
+		log.Printf("Error displaying file: not allowed: %v", err)
+		mu.Lock()
+		if status, _ := resourceCache.Get(hash); status == cache.StatusNotFound {
+			resourceCache.Put(hash, NewRefusedResource(tempDir, remote, hash, err.Error()))
+		}
+		selectHash(channel, remote, "", hash)
+		mu.Unlock()
+		util.RespondJsonStatus(w, http.StatusForbidden, map[string]interface{}{
+			"status":  "error",
+			"message": fmt.Sprintf("Not allowed: %v", err),
+		})
+		return
+	}
+
+	// skip the body when this version of the file is already cached; the client waits
+	// for 100 Continue before sending it, so a cache hit costs no transfer
+	status, _ := resourceCache.Get(hash)
+	if status == cache.StatusNotFound {
+		cacheMisses.Inc()
+		dir := filepath.Join(tempDir, "upload-"+hash)
+		temp, err := saveUpload(r, dir)
+		if err != nil {
+			log.Printf("Error receiving upload: %v", err)
+			util.RespondJsonStatus(w, http.StatusBadRequest, map[string]interface{}{
+				"status":  "error",
+				"message": fmt.Sprintf("Error receiving upload: %v", err),
+			})
+			return
+		}
+
+		// the first upload to finish keeps its copy under the remote file name so the rules match its extension
+		mu.Lock()
+		if status, _ := resourceCache.Get(hash); status == cache.StatusNotFound {
+			local := filepath.Join(dir, path.Base(strings.ReplaceAll(file, "\\", "/")))
+			if err := os.Rename(temp, local); err != nil {
+				log.Printf("Error receiving upload: %v", err)
+				local = temp
+			}
+			resourceCache.Put(hash, NewUploadResource(tempDir, local, remote, size, hash))
+		} else {
+			os.Remove(temp)
+		}
+		mu.Unlock()
+	} else {
+		cacheHits.Inc()
+	}
+
+	mu.Lock()
+	selectHash(channel, remote, "", hash)
+	mu.Unlock()
+	util.RespondJson(w, map[string]interface{}{
+		"status": template.HTML("success"),
+		"hash":   template.HTML(hash),
+	})
+}
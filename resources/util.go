@@ -3,14 +3,18 @@ package resources
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ccammack/cannon/builtin"
 	"github.com/ccammack/cannon/config"
 	"github.com/ccammack/cannon/util"
 )
@@ -18,8 +22,10 @@ import (
 func GetMimeType(file string) string {
 	_, command := config.Mime().Strings()
 	if len(command) > 0 {
+		start := time.Now()
 		cmd, args := util.FormatCommand(command, map[string]string{"{input}": file})
 		out, _ := exec.Command(cmd, args...).CombinedOutput()
+		mimeSeconds.Observe(time.Since(start).Seconds())
 		return strings.TrimSuffix(string(out), "\n")
 	}
 	return ""
@@ -44,32 +50,72 @@ func findMatchingOutputFile(output string) string {
 }
 
 func runAndWait(resource *Resource, rule ConversionRule) int {
-	cmd, args := util.FormatCommand(rule.cmd, map[string]string{
+	return runCommand(resource, rule.cmd, nil)
+}
+
+func countPages(resource *Resource, rule ConversionRule) int {
+	// run the pages: command in a scratch resource to keep the conversion output intact
+	probe := &Resource{file: resource.file, tmpOutputFile: resource.tmpOutputFile, rule: resource.rule, page: resource.page}
+	exit := runCommand(probe, rule.pages, nil)
+	resource.progress = append(resource.progress, probe.progress...)
+	if exit != 0 {
+		resource.progress = append(resource.progress, fmt.Sprintf("Page count failed with status code: %d", exit))
+		return 0
+	}
+
+	// use the first number in the output
+	pages, err := strconv.Atoi(regexp.MustCompile(`\d+`).FindString(probe.stdout))
+	if err != nil {
+		resource.progress = append(resource.progress, fmt.Sprintf("Error reading page count: %s", summarize(probe.stdout)))
+		return 0
+	}
+	resource.progress = append(resource.progress, fmt.Sprintf("Count pages: %d", pages))
+	return pages
+}
+
+func runCommand(resource *Resource, command []string, placeholders map[string]string) int {
+	subs := map[string]string{
 		"{input}":  resource.file,
 		"{output}": resource.tmpOutputFile,
-	})
+		"{page}":   strconv.Itoa(resource.page),
+		"{theme}":  resource.theme,
+	}
+	for k, v := range placeholders {
+		subs[k] = v
+	}
+	cmd, args := util.FormatCommand(command, subs)
 
-	resource.progress = append(resource.progress, fmt.Sprintf("Run command: %v %v", cmd, args))
+	resource.command = fmt.Sprintf("%v %v", cmd, args)
+	resource.progress = append(resource.progress, fmt.Sprintf("Run command: %s", resource.command))
 
 	// timeout
 	_, timeout := config.Timeout().Int()
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+	ctx, cancel := context.WithTimeout(conversionCtx, time.Duration(timeout)*time.Millisecond)
 	defer cancel()
 
 	// prepare command
 	var outb, errb bytes.Buffer
-	command := exec.CommandContext(ctx, cmd, args...)
-	command.Stdout = &outb
-	command.Stderr = &errb
+	proc := exec.CommandContext(ctx, cmd, args...)
+	proc.Stdout = &outb
+	proc.Stderr = &errb
 
 	// run command
-	err := command.Run()
+	err := proc.Run()
 	resource.stdout = outb.String()
 	resource.stderr = errb.String()
 
 	// fail if the command takes too long
 	if ctx.Err() == context.DeadlineExceeded {
 		resource.progress = append(resource.progress, "Command timed out!")
+		conversionTimeouts.Inc(resource.ruleLabels()...)
+		resource.exit = 255
+		return 255
+	}
+
+	// stop quietly if the server is shutting down
+	if ctx.Err() == context.Canceled {
+		resource.progress = append(resource.progress, "Command cancelled")
+		resource.exit = 255
 		return 255
 	}
 
@@ -85,9 +131,37 @@ func runAndWait(resource *Resource, rule ConversionRule) int {
 		}
 	}
 
+	resource.exit = exit
 	return exit
 }
 
+func runBuiltin(resource *Resource, rule ConversionRule) (string, error) {
+	converter, ok := builtin.Lookup(rule.builtin)
+	if !ok {
+		return "", fmt.Errorf("unknown builtin converter: %s", rule.builtin)
+	}
+
+	resource.progress = append(resource.progress, fmt.Sprintf("Run builtin: %s %s", rule.builtin, resource.srcFile))
+
+	// timeout
+	_, timeout := config.Timeout().Int()
+	ctx, cancel := context.WithTimeout(conversionCtx, time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+
+	html, err := converter(ctx, builtin.Request{
+		Input:  resource.srcFile,
+		Output: resource.tmpOutputFile,
+		Url:    resource.url(),
+	})
+
+	// fail if the converter takes too long
+	if ctx.Err() == context.DeadlineExceeded {
+		conversionTimeouts.Inc(resource.ruleLabels()...)
+		return "", errors.New("builtin timed out")
+	}
+	return html, err
+}
+
 func createPreviewFile(tempDir string) string {
 	// create a temp file to hold the output preview file
 	fp, err := os.CreateTemp(tempDir, "preview")
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/adrg/xdg"
+	"github.com/ccammack/cannon/config"
+)
+
+// listen on the loopback interfaces unless the config says otherwise
+var defaultListen = []string{"127.0.0.1", "::1"}
+
+// the running server writes its address here for the client to find
+var addressPath = xdg.RuntimeDir + "/cannon.addr"
+
+// the address of this process when it is the server
+var address string
+
+// clients on another host name the server here, usually the local end of an ssh tunnel
+const remoteUrl = "CANNON_URL"
+
+func portRange() (int, int, error) {
+	// accept a port number, a range of ports like 8888-8899, or auto to let the system choose
+	key, port := config.Port().String()
+	if port == "auto" {
+		return 0, 0, nil
+	}
+	first, last, isRange := strings.Cut(port, "-")
+	low, err := strconv.Atoi(strings.TrimSpace(first))
+	high := low
+	if err == nil && isRange {
+		high, err = strconv.Atoi(strings.TrimSpace(last))
+	}
+	if err != nil || low < 0 || high < low || high > 65535 {
+		return 0, 0, fmt.Errorf("%s: expected a port, a range like 8888-8899 or auto: %s", key, port)
+	}
+	return low, high, nil
+}
+
+func listenAddresses(port int) []string {
+	_, listen := config.Listen().Strings()
+	if len(listen) == 0 {
+		listen = defaultListen
+	}
+
+	// accept host:port, [ipv6]:port or a bare host that uses the port: setting
+	addresses := []string{}
+	for _, entry := range listen {
+		if _, _, err := net.SplitHostPort(entry); err == nil {
+			addresses = append(addresses, entry)
+			continue
+		}
+		host := strings.TrimSuffix(strings.TrimPrefix(entry, "["), "]")
+		addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	return addresses
+}
+
+func bind(port int) []net.Listener {
+	// the first address must be bound, since that is the one the client uses
+	listeners := []net.Listener{}
+	addrs := listenAddresses(port)
+	for i := 0; i < len(addrs); i++ {
+		addr := addrs[i]
+		listener, err := net.Listen("tcp", addr)
+		if err != nil && i == 0 {
+			log.Printf("Error listening on %s: %v", addr, err)
+			return nil
+		}
+		if err != nil {
+			// ::1 is missing on hosts without ipv6
+			log.Printf("Error listening on %s: %v", addr, err)
+			continue
+		}
+		if port == 0 {
+			// share the port the system chose with the remaining addresses
+			port = listener.Addr().(*net.TCPAddr).Port
+			addrs = listenAddresses(port)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners
+}
+
+func listen() ([]net.Listener, error) {
+	// use the first port in the range that can be bound
+	first, last, err := portRange()
+	if err != nil {
+		return nil, err
+	}
+	for port := first; port <= last; port++ {
+		if listeners := bind(port); len(listeners) != 0 {
+			return listeners, nil
+		}
+	}
+	return nil, fmt.Errorf("no listen addresses available")
+}
+
+func localUrl(hostport string) string {
+	// reach the address, using loopback for wildcard addresses
+	host, port, _ := net.SplitHostPort(hostport)
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	return scheme() + "://" + net.JoinHostPort(host, port)
+}
+
+func publishAddress(listener net.Listener) error {
+	// only the current user needs to find the server
+	address = localUrl(listener.Addr().String())
+	return os.WriteFile(addressPath, []byte(address+"\n"), 0600)
+}
+
+func unpublishAddress() error {
+	address = ""
+	return os.RemoveAll(addressPath)
+}
+
+func serverUrl() string {
+	// the server knows its own address; clients read the one it published unless told otherwise
+	if address != "" {
+		return address
+	}
+	if url := os.Getenv(remoteUrl); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	contents, err := os.ReadFile(addressPath)
+	if err == nil {
+		return strings.TrimSpace(string(contents))
+	}
+
+	// fall back to the first configured address
+	first, _, _ := portRange()
+	return localUrl(listenAddresses(first)[0])
+}
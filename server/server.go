@@ -8,36 +8,65 @@ import (
 	"html/template"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/ccammack/cannon/assets"
 	"github.com/ccammack/cannon/config"
+	"github.com/ccammack/cannon/metrics"
 	"github.com/ccammack/cannon/pid"
 	"github.com/ccammack/cannon/resources"
+	"github.com/ccammack/cannon/session"
 	"github.com/ccammack/cannon/util"
 )
 
 var (
-	server *http.Server
+	server   *http.Server
+	stopOnce sync.Once
+	stopped  = make(chan struct{})
 )
 
+// how long to wait for requests, then conversions, to finish when stopping
+const shutdownTimeout = 5 * time.Second
+
 func shutdown() {
-	// normal cleanup
-	resources.Shutdown()
+	// run once in the background so /stop can finish its own response
+	stopOnce.Do(func() {
+		go func() {
+			defer close(stopped)
 
-	// unlock pid
-	pid.Unlock()
+			// stop accepting requests and let the current ones finish
+			if server != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+				if err := server.Shutdown(ctx); err != nil {
+					log.Printf("Error stopping server: %v", err)
+				}
+				cancel()
+			}
 
-	go func() {
-		// shutdown
-		if err := server.Shutdown(context.Background()); err != nil {
-			log.Printf("Error stopping server: %v", err)
-		}
-	}()
+			// notify viewers, reap conversions and remove temp data, with a deadline of their own
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			resources.Shutdown(ctx)
+
+			// unlock pid
+			pid.Unlock()
+
+			// forget the session token and address
+			session.Remove()
+			unpublishAddress()
+
+			log.Printf("Server stopped")
+		}()
+	})
 }
 
 func handleStop(w http.ResponseWriter, r *http.Request) {
@@ -68,17 +97,47 @@ func Start() {
 	}()
 
 	// check for running server
-	if err := pid.IsRunning(); err != nil {
-		log.Printf("Error starting server: %v", err)
+	if pid.IsRunning() {
+		log.Printf("Error starting server: process is already running")
 		return
 	}
 
 	// lock pid
 	pid.Lock()
+	started = time.Now()
+
+	// create the session token shared with the client and browser
+	token, err := session.Create()
+	if err != nil {
+		log.Printf("Error creating session token: %v", err)
+		pid.Unlock()
+		return
+	}
+
+	// bind the configured addresses, prepare the https certificate and publish the address the client should use
+	certFile, keyFile := "", ""
+	listeners, err := listen()
+	if err == nil && tlsEnabled() {
+		certFile, keyFile, err = prepareCertificate(listeners)
+		if err != nil {
+			err = fmt.Errorf("preparing certificate: %v", err)
+		}
+	}
+	if err == nil {
+		err = publishAddress(listeners[0])
+	}
+	if err != nil {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		unpublishAddress()
+		session.Remove()
+		pid.Unlock()
+		log.Fatalf("Error starting server: %v", err)
+	}
 
 	// log server address
-	_, port := config.Port().Int()
-	url := fmt.Sprintf("http://localhost:%d", port)
+	url := serverUrl() + "/?" + session.Param + "=" + token
 	log.Printf("Starting server: %s", url)
 
 	// start preview browser
@@ -89,31 +148,54 @@ func Start() {
 
 	// validate server config
 	config.Validate()
-
-	// broadcast
-	go func() {
-		for {
-			resources.BroadcastCurrent()
-			time.Sleep(33 * time.Millisecond)
-		}
-	}()
+	if missing := assets.Missing(); len(missing) != 0 {
+		log.Printf("Error finding assets in %s: %v (loading them from the cdn; run 'cannond assets fetch' to download them)", assets.Dir(), missing)
+	}
 
 	// listen and serve
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", resources.HandleRoot)
-	mux.HandleFunc("/src/", resources.HandleSrc)
-	mux.HandleFunc("/display", resources.HandleDisplay)
-	mux.HandleFunc("/stop", handleStop)
-	mux.HandleFunc("/close", resources.HandleClose)
+	mux.HandleFunc("/", session.Browser(resources.HandleRoot))
+	mux.HandleFunc("/src/", session.Browser(resources.HandleSrc))
+	mux.HandleFunc(assets.Prefix+"/", session.Browser(assets.Handler))
+	mux.HandleFunc("/display", session.Client(resources.HandleDisplay))
+	mux.HandleFunc("/stop", session.Client(handleStop))
+	mux.HandleFunc("/close", session.Client(resources.HandleClose))
+	mux.HandleFunc("/upload", session.Client(resources.HandleUpload))
+	mux.HandleFunc("/api/", session.Either(resources.HandleApi))
+	mux.HandleFunc("/api/status", session.Either(handleStatus))
+	mux.HandleFunc("/metrics", session.Either(metrics.Handler))
 	server = &http.Server{
-		Addr:    fmt.Sprintf(":%v", port),
 		Handler: mux,
 	}
-	log.Fatal(server.ListenAndServe())
+
+	// serve every bound address, using https when a certificate was prepared
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		log.Printf("Listening on %s", listener.Addr())
+		go func(listener net.Listener) {
+			if certFile != "" {
+				errs <- server.ServeTLS(listener, certFile, keyFile)
+			} else {
+				errs <- server.Serve(listener)
+			}
+		}(listener)
+	}
+	err = <-errs
+	if err != http.ErrServerClosed {
+		// clean up as if stopped before reporting the failure
+		log.Printf("Error serving: %v", err)
+		shutdown()
+	}
+
+	// wait for the cleanup to finish before exiting
+	<-stopped
+	if err != http.ErrServerClosed {
+		os.Exit(1)
+	}
 }
 
 func Stop() {
-	if err := pid.IsRunning(); err == nil {
+	if !pid.IsRunning() {
 		log.Printf("Error stopping server (already stopped?)")
 	} else {
 		Request("POST", "stop", nil)
@@ -121,40 +203,40 @@ func Stop() {
 }
 
 func Toggle() {
-	if err := pid.IsRunning(); err != nil {
+	if pid.IsRunning() {
 		Stop()
 	} else {
 		Start()
 	}
 }
 
-func Request(method string, resource string, params map[string]string) {
-	if err := pid.IsRunning(); err == nil {
-		log.Printf("Server is not running (use --start or --toggle to start)")
-	}
-
-	_, port := config.Port().Int()
-	url := fmt.Sprintf("http://localhost:%d/%s", port, resource)
-
+func fetch(method string, resource string, params map[string]string) (int, []byte, error) {
 	// prepare request
 	json, err := json.Marshal(params)
 	if err != nil {
-		log.Printf("Error marshalling request params: %v", err)
-		return
+		return 0, nil, fmt.Errorf("marshalling request params: %v", err)
 	}
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(json))
+	return send(method, resource, bytes.NewBuffer(json))
+}
+
+func send(method string, resource string, body io.Reader) (int, []byte, error) {
+	url := fmt.Sprintf("%s/%s", serverUrl(), resource)
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		return
+		return 0, nil, fmt.Errorf("creating request: %v", err)
 	}
 	req.Header.Set("Accept", "application/json")
+	req.Header.Set(session.Header, session.Token())
+	if strings.HasPrefix(resource, "upload?") {
+		// let the server turn down the body of a file it already has
+		req.Header.Set("Expect", "100-continue")
+	}
 
 	// send it
-	client := &http.Client{}
+	client := httpClient()
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("Error making request: %v", err)
-		return
+		return 0, nil, fmt.Errorf("making request: %v", err)
 	}
 
 	// check header
@@ -163,9 +245,44 @@ func Request(method string, resource string, params map[string]string) {
 
 	// read response body
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("reading response: %v", err)
+	}
+	return resp.StatusCode, contents, nil
+}
+
+func Request(method string, resource string, params map[string]string) {
+	if os.Getenv(remoteUrl) == "" && !pid.IsRunning() {
+		log.Printf("Server is not running (use --start or --toggle to start)")
+	}
+
+	_, body, err := fetch(method, resource, params)
+	if err != nil {
+		log.Printf("Error %v", err)
+		return
+	}
+
+	// log the result
+	log.Printf("%v", string(body))
+}
+
+func Upload(file string, params map[string]string) {
+	// send a prefix of the file to a server on another host, usually through an ssh tunnel
+	fp, err := os.Open(file)
+	if err != nil {
+		log.Printf("Error opening file: %v", err)
+		return
+	}
+	defer fp.Close()
+
+	query := neturl.Values{}
+	for k, v := range params {
+		query.Set(k, v)
+	}
+	_, body, err := send("POST", "upload?"+query.Encode(), io.LimitReader(fp, resources.UploadLimit()))
 	if err != nil {
-		log.Printf("Error reading response: %v", err)
+		log.Printf("Error %v", err)
 		return
 	}
 
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ccammack/cannon/config"
+	"github.com/ccammack/cannon/connections"
+	"github.com/ccammack/cannon/pid"
+	"github.com/ccammack/cannon/resources"
+	"github.com/ccammack/cannon/util"
+)
+
+// exit codes for cannond status, following the LSB init script conventions
+const (
+	StatusRunning    = 0
+	StatusNotHealthy = 1 // the process exists but the server did not answer
+	StatusStopped    = 3
+)
+
+var started time.Time
+
+type status struct {
+	Status       string                   `json:"status"`
+	Pid          int                      `json:"pid,omitempty"`
+	Url          string                   `json:"url,omitempty"`
+	Started      *time.Time               `json:"started,omitempty"`
+	Uptime       float64                  `json:"uptime,omitempty"`
+	Viewers      int                      `json:"viewers"`
+	Resources    []map[string]interface{} `json:"resources,omitempty"`
+	Counts       map[string]int           `json:"counts,omitempty"`
+	TempDir      string                   `json:"tempDir,omitempty"`
+	TempDirBytes int64                    `json:"tempDirBytes"`
+	Config       string                   `json:"config"`
+	Error        string                   `json:"error,omitempty"`
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	stats := resources.Stats()
+	util.RespondJson(w, map[string]interface{}{
+		"status":       "running",
+		"pid":          os.Getpid(),
+		"url":          serverUrl(),
+		"started":      started,
+		"uptime":       time.Since(started).Seconds(),
+		"viewers":      len(connections.Viewers()),
+		"resources":    stats["resources"],
+		"counts":       stats["counts"],
+		"tempDir":      stats["tempDir"],
+		"tempDirBytes": stats["tempDirBytes"],
+		"config":       config.Path(),
+	})
+}
+
+func queryStatus() (status, int) {
+	current := status{Status: "stopped", Config: config.Path()}
+	if !pid.IsRunning() {
+		return current, StatusStopped
+	}
+
+	// ask the running server for the details
+	current.Pid = pid.Pid()
+	code, body, err := fetch("GET", "api/status", nil)
+	if err == nil && code != http.StatusOK {
+		err = fmt.Errorf("%d %s", code, http.StatusText(code))
+	}
+	if err == nil {
+		err = json.Unmarshal(body, &current)
+	}
+	if err != nil {
+		current.Status = "not responding"
+		current.Error = err.Error()
+		return current, StatusNotHealthy
+	}
+	return current, StatusRunning
+}
+
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	size := float64(n)
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", n, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
+
+func Status(asJson bool) int {
+	current, code := queryStatus()
+	if asJson {
+		bytes, _ := json.MarshalIndent(current, "", " ")
+		fmt.Println(string(bytes))
+		return code
+	}
+
+	fmt.Printf("cannond is %s\n", current.Status)
+	if current.Error != "" {
+		fmt.Printf("  error:     %s\n", current.Error)
+	}
+	if code == StatusRunning {
+		states := []string{}
+		for state, count := range current.Counts {
+			states = append(states, fmt.Sprintf("%d %s", count, state))
+		}
+		sort.Strings(states)
+		fmt.Printf("  pid:       %d\n", current.Pid)
+		fmt.Printf("  url:       %s\n", current.Url)
+		fmt.Printf("  uptime:    %v\n", (time.Duration(current.Uptime) * time.Second).Round(time.Second))
+		fmt.Printf("  viewers:   %d\n", current.Viewers)
+		fmt.Printf("  resources: %d (%s)\n", len(current.Resources), strings.Join(states, ", "))
+		for _, res := range current.Resources {
+			fmt.Printf("             %-8v %v\n", res["status"], res["file"])
+		}
+		fmt.Printf("  temp dir:  %s (%s)\n", current.TempDir, formatBytes(current.TempDirBytes))
+	} else if current.Pid != 0 {
+		fmt.Printf("  pid:       %d\n", current.Pid)
+	}
+	fmt.Printf("  config:    %s\n", current.Config)
+	return code
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ccammack/cannon/config"
+)
+
+// regenerate the self-signed certificate this long before it expires
+const certRenewal = 30 * 24 * time.Hour
+
+// clients on another host find a copy of the server's certificate here
+const remoteCA = "CANNON_CA"
+
+func tlsEnabled() bool {
+	_, enabled := config.TLS().Bool()
+	return enabled
+}
+
+func scheme() string {
+	if tlsEnabled() {
+		return "https"
+	}
+	return "http"
+}
+
+func tlsFiles() (string, string) {
+	// use the configured pair or the self-signed certificate cached beside the config
+	_, cert := config.TLSCert().String()
+	_, key := config.TLSKey().String()
+	if cert != "" && key != "" {
+		return cert, key
+	}
+	return filepath.Join(config.Dir(), "cert.pem"), filepath.Join(config.Dir(), "key.pem")
+}
+
+func interfaceIPs() []net.IP {
+	ips := []net.IP{}
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+				ips = append(ips, ipnet.IP)
+			}
+		}
+	}
+	return ips
+}
+
+func listenIPs(listeners []net.Listener) []net.IP {
+	// wildcard listeners are reachable on every local address
+	ips := []net.IP{}
+	for _, listener := range listeners {
+		ip := listener.Addr().(*net.TCPAddr).IP
+		if ip.IsUnspecified() {
+			ips = append(ips, interfaceIPs()...)
+		} else if !ip.IsLoopback() {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+func certificateHosts(listeners []net.Listener) ([]string, []net.IP) {
+	// cover the loopback names, the hostname, every local address and the listen addresses
+	names := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil {
+		names = append(names, hostname)
+	}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	for _, ip := range append(interfaceIPs(), listenIPs(listeners)...) {
+		if !containsIP(ips, ip) {
+			ips = append(ips, ip)
+		}
+	}
+	return names, ips
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, other := range ips {
+		if other.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func certificateCovers(cert *x509.Certificate, listeners []net.Listener) bool {
+	// addresses come and go, so check the ones in use against the stored names
+	for _, ip := range append([]net.IP{net.IPv4(127, 0, 0, 1)}, listenIPs(listeners)...) {
+		if cert.VerifyHostname(ip.String()) != nil {
+			return false
+		}
+	}
+	return cert.VerifyHostname("localhost") == nil
+}
+
+func readCertificate(file string) (*x509.Certificate, error) {
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no certificate found in " + file)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func generateCertificate(certFile, keyFile string, listeners []net.Listener) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	names, ips := certificateHosts(listeners)
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Cannon"}, CommonName: "cannond"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+		DNSNames:              names,
+		IPAddresses:           ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	// keep the private key readable only by the current user
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func prepareCertificate(listeners []net.Listener) (string, string, error) {
+	certFile, keyFile := tlsFiles()
+	_, cert := config.TLSCert().String()
+	if cert != "" {
+		// never replace certificates provided by the user
+		return certFile, keyFile, nil
+	}
+
+	// replace certificates that are expiring, were issued as a ca or miss a listen address
+	existing, err := readCertificate(certFile)
+	if err == nil && time.Until(existing.NotAfter) > certRenewal && !existing.IsCA && certificateCovers(existing, listeners) {
+		return certFile, keyFile, nil
+	}
+	log.Printf("Generating self-signed certificate: %s", certFile)
+	return certFile, keyFile, generateCertificate(certFile, keyFile, listeners)
+}
+
+func httpClient() *http.Client {
+	// keep the default transport, which waits briefly for 100 Continue before sending upload bodies
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	certFile := os.Getenv(remoteCA)
+	if certFile == "" && tlsEnabled() {
+		certFile, _ = tlsFiles()
+	}
+	if certFile == "" {
+		return &http.Client{Transport: transport}
+	}
+
+	// trust the server's certificate in addition to the system roots
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	contents, err := os.ReadFile(certFile)
+	if err != nil {
+		log.Printf("Error reading certificate: %v", err)
+	} else {
+		pool.AppendCertsFromPEM(contents)
+	}
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,138 @@
+package session
+
+// a per-session secret shared by cannond, the cannon client and the preview browser
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/adrg/xdg"
+)
+
+const (
+	Header = "X-Cannon-Token" // sent by the cannon client with control requests
+	Cookie = "cannon-session" // set in the browser after it opens /?token=<token>, followed by the port
+	Param  = "token"
+)
+
+var (
+	tokenPath = xdg.RuntimeDir + "/cannon.token"
+	token     string
+)
+
+// clients on another host receive the token in the environment instead
+const tokenEnv = "CANNON_TOKEN"
+
+func Create() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	token = hex.EncodeToString(bytes)
+
+	// only the current user may read the token
+	return token, os.WriteFile(tokenPath, []byte(token+"\n"), 0600)
+}
+
+func Remove() error {
+	token = ""
+	return os.RemoveAll(tokenPath)
+}
+
+func Token() string {
+	// the server keeps its own copy; clients read it from the runtime dir
+	if token != "" {
+		return token
+	}
+	if env := os.Getenv(tokenEnv); env != "" {
+		return env
+	}
+	contents, err := os.ReadFile(tokenPath)
+	if err != nil {
+		log.Printf("Error reading session token: %v", err)
+		return ""
+	}
+	return strings.TrimSpace(string(contents))
+}
+
+func valid(candidate string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1
+}
+
+func cookieName(r *http.Request) string {
+	// browsers share cookies across ports, so servers on the same host need their own names
+	if _, port, err := net.SplitHostPort(r.Host); err == nil && port != "" {
+		return Cookie + "-" + port
+	}
+	return Cookie
+}
+
+func forbidden(w http.ResponseWriter) {
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
+func Client(handler http.HandlerFunc) http.HandlerFunc {
+	// control endpoints require the token header, which other web pages cannot send
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !valid(r.Header.Get(Header)) {
+			forbidden(w)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func Browser(handler http.HandlerFunc) http.HandlerFunc {
+	// viewer endpoints require the session cookie
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if candidate := query.Get(Param); candidate != "" {
+			if !valid(candidate) {
+				forbidden(w)
+				return
+			}
+
+			// trade the token in the url for a cookie and drop it from the address bar
+			http.SetCookie(w, &http.Cookie{
+				Name:     cookieName(r),
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			query.Del(Param)
+			url := *r.URL
+			url.RawQuery = query.Encode()
+			http.Redirect(w, r, url.RequestURI(), http.StatusSeeOther)
+			return
+		}
+
+		cookie, err := r.Cookie(cookieName(r))
+		if err != nil || !valid(cookie.Value) {
+			forbidden(w)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func Either(handler http.HandlerFunc) http.HandlerFunc {
+	// read-only endpoints accept the token header or the session cookie
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !valid(r.Header.Get(Header)) {
+			cookie, err := r.Cookie(cookieName(r))
+			if err != nil || !valid(cookie.Value) {
+				forbidden(w)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
@@ -17,9 +17,13 @@ import (
 )
 
 func RespondJson(w http.ResponseWriter, data map[string]interface{}) {
+	RespondJsonStatus(w, http.StatusOK, data)
+}
+
+func RespondJsonStatus(w http.ResponseWriter, status int, data map[string]interface{}) {
 	// json
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
 
@@ -156,6 +160,25 @@ func HashPath(file string) (string, string, error) {
 	return hash, path, nil
 }
 
+func HashRemote(host string, file string, mtime string) string {
+	// uploaded files change whenever they are modified on the remote host
+	return MakeHash(host + ":" + file + "@" + mtime)
+}
+
+func HashPathPair(file string, other string) (string, string, string, error) {
+	// hash both paths so each comparison gets its own resource
+	_, path, err := HashPath(file)
+	if err != nil {
+		return "", "", "", err
+	}
+	_, otherPath, err := HashPath(other)
+	if err != nil {
+		return "", "", "", err
+	}
+	hash := MakeHash(path + "\x00" + otherPath)
+	return hash, path, otherPath, nil
+}
+
 func CopyFileContents(src, dst string) (err error) {
 	// https://stackoverflow.com/a/21067803
 	in, err := os.Open(src)